@@ -45,8 +45,18 @@ func main() {
 		PersistentPreRunE: cli.PersistentPreRunE,
 		SilenceUsage:      true,
 	}
+	webapp := &cobra.Command{
+		Use:          "webapp",
+		Short:        "Manage the client configuration generator webserver.",
+		SilenceUsage: true,
+	}
+	webapp.AddCommand(
+		cli.RunWebServerCmd(),
+		cli.NewSessionKey(),
+	)
 	peers.AddCommand(
 		cli.PeerAddCmd(),
+		cli.PeerDeleteCmd(),
 		cli.PeerListCmd(),
 		cli.PeerInfoCmd(),
 		cli.PeerBlockCmd(),
@@ -56,16 +66,26 @@ func main() {
 	servers.AddCommand(
 		cli.InitServer(),
 		cli.ListServer(),
+		cli.UpdateServer(),
+		cli.EditServer(),
+		cli.RotateServerKey(),
 		cli.DeleteServer(),
+		cli.RotateCipherKey(),
+		cli.MigrateEncryption(),
 		cli.NewCipherKey(),
 	)
+	// kept for backward compatibility, it's the same as "webapp run-server"
+	runServer := cli.RunWebServerCmd()
+	runServer.Hidden = true
+	runServer.Deprecated = `use "webapp run-server" instead`
 	root.AddCommand(
 		servers,
 		peers,
+		webapp,
 		cli.InstallDaemons(),
 		cli.SyncServerCmd(),
 		cli.SyncPeersCmd(),
-		cli.RunWebServerCmd(),
+		runServer,
 	)
 	root.PersistentFlags().BoolVar(&cli.O.Local, "local", false, "Fetch from local database instead of remote.")
 	root.PersistentFlags().BoolVar(&cli.O.ShowVersionAndExit, "version", false, "Show version.")
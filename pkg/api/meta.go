@@ -20,15 +20,23 @@ import (
 )
 
 func (w *WebApp) SetDefaults() {
-	if w.GCSBucketName != "" {
-		os.Setenv("GCS_BUCKET_NAME", w.GCSBucketName)
-	}
 	if w.GoogleApplicationCredentials != "" {
 		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", w.GoogleApplicationCredentials)
 	}
 	if w.HTTPPort == "" {
 		w.HTTPPort = "8000"
 	}
+	if w.OIDC != nil {
+		if len(w.OIDC.Scopes) == 0 {
+			w.OIDC.Scopes = []string{"openid", "email", "profile"}
+		}
+		if w.OIDC.EmailClaim == "" {
+			w.OIDC.EmailClaim = "email"
+		}
+		if w.OIDC.GroupsClaim == "" {
+			w.OIDC.GroupsClaim = "groups"
+		}
+	}
 	if w.PageConfig != nil {
 		if w.PageConfig.LogoURL == "" {
 			w.PageConfig.LogoURL = "/static/img/logo.png"
@@ -53,11 +61,118 @@ func (d ServerDaemon) GetSystemdPath() string { return d.SystemdPath }
 func (d PeerDaemon) GetUnitName() string      { return d.UnitName }
 func (d PeerDaemon) GetSystemdPath() string   { return d.SystemdPath }
 
+// GetStaleThreshold returns the stale threshold or three times the sync time if it's not set
+func (d ServerDaemon) GetStaleThreshold() time.Duration {
+	return staleThreshold(d.StaleThreshold, d.SyncTime)
+}
+
+// GetStaleThreshold returns the stale threshold or three times the sync time if it's not set
+func (d PeerDaemon) GetStaleThreshold() time.Duration {
+	return staleThreshold(d.StaleThreshold, d.SyncTime)
+}
+
+// GetStatsInterval returns the stats interval or its default if it's not set
+func (d PeerDaemon) GetStatsInterval() time.Duration {
+	if d.StatsInterval <= 0 {
+		return PeerDefaultStatsInterval
+	}
+	return time.Duration(d.StatsInterval)
+}
+
+// NextInterval returns the interval until the next sync after the given consecutive failures
+func (p SyncPolicy) NextInterval(syncTime Duration, failures int) time.Duration {
+	interval := util.Backoff(time.Duration(syncTime), time.Duration(p.MaxBackoff), failures)
+	return util.Jitter(interval, p.Jitter)
+}
+
+func staleThreshold(threshold, syncTime Duration) time.Duration {
+	if threshold != Duration(0) {
+		return time.Duration(threshold)
+	}
+	return 3 * time.Duration(syncTime)
+}
+
+// KeyPair decodes the session key to a hash and block key pair
+func (k SessionKey) KeyPair() ([]byte, []byte, error) {
+	hashKey, err := base64.StdEncoding.DecodeString(k.HashKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed decoding hash key: %v", err)
+	}
+	if len(hashKey) == 0 {
+		return nil, nil, fmt.Errorf("hash key is empty")
+	}
+	if k.BlockKey == "" {
+		return hashKey, nil, nil
+	}
+	blockKey, err := base64.StdEncoding.DecodeString(k.BlockKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed decoding block key: %v", err)
+	}
+	switch len(blockKey) {
+	case 16, 24, 32:
+	default:
+		return nil, nil, fmt.Errorf("block key must have 16, 24 or 32 bytes, found %d", len(blockKey))
+	}
+	return hashKey, blockKey, nil
+}
+
+// GetRemoteConfig returns the remote backend config of the webapp,
+// it fallbacks to a GCS backend using the gcsBucketName attribute.
+func (w *WebApp) GetRemoteConfig() *RemoteConfig {
+	if w.Remote != nil {
+		return w.Remote
+	}
+	return &RemoteConfig{Type: RemoteBackendGCS, BucketName: w.GCSBucketName}
+}
+
+// GetRemoteConfig returns the remote backend config of the daemons,
+// it fallbacks to a GCS backend using the bucketName attribute.
+func (d ServerConfig) GetRemoteConfig() *RemoteConfig {
+	if d.Remote != nil {
+		return d.Remote
+	}
+	return &RemoteConfig{Type: RemoteBackendGCS, BucketName: d.BucketName}
+}
+
+// NewRemoteConfigFromEnv builds a remote backend config from environment variables,
+// the backend type defaults to GCS for keeping compatibility with GCS_BUCKET_NAME.
+func NewRemoteConfigFromEnv() *RemoteConfig {
+	c := &RemoteConfig{
+		Type:       RemoteBackendType(os.Getenv("WGADMIN_REMOTE_TYPE")),
+		BucketName: os.Getenv("WGADMIN_BUCKET_NAME"),
+		Path:       os.Getenv("WGADMIN_REMOTE_PATH"),
+	}
+	if c.Type == "" {
+		c.Type = RemoteBackendGCS
+	}
+	if c.BucketName == "" {
+		c.BucketName = os.Getenv("GCS_BUCKET_NAME")
+	}
+	if c.Type == RemoteBackendS3 {
+		c.S3 = &S3Config{
+			Endpoint:       os.Getenv("WGADMIN_S3_ENDPOINT"),
+			Region:         os.Getenv("AWS_REGION"),
+			ForcePathStyle: os.Getenv("WGADMIN_S3_ENDPOINT") != "",
+		}
+	}
+	return c
+}
+
+// GetCipherKeys returns the cipher key followed by the additional ones
+func (d ServerDaemon) GetCipherKeys() []string {
+	return append([]string{d.CipherKey}, d.CipherKeys...)
+}
+
 // GetWireguardConfigFile returns the path to the wireguard config file
 func (d ServerConfig) GetWireguardConfigFile() string {
 	return filepath.Join(d.ServerDaemon.ConfigPath, d.ServerDaemon.ConfigFile)
 }
 
+// GetInterfaceName returns the name of the interface managed by wg-quick
+func (d ServerConfig) GetInterfaceName() string {
+	return strings.TrimSuffix(d.ServerDaemon.ConfigFile, filepath.Ext(d.ServerDaemon.ConfigFile))
+}
+
 // UnmarshalJSON deserialize a time.Duration
 func (d *Duration) UnmarshalJSON(data []byte) error {
 	unquoted, err := strconv.Unquote(string(data))
@@ -108,6 +223,17 @@ func (k *Key) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+// MatchLabels verify if the object has all the labels of the selector,
+// an empty selector matches any object
+func (m *Metadata) MatchLabels(selector map[string]string) bool {
+	for key, val := range selector {
+		if v, ok := m.Labels[key]; !ok || v != val {
+			return false
+		}
+	}
+	return true
+}
+
 // PublicKeyString return the public key from a peer,
 // returns an empty string if it's empty
 func (p *Peer) PublicKeyString() string {
@@ -178,11 +304,38 @@ func (p *Peer) ParseExpireDuration() time.Duration {
 	return d
 }
 
-// ParseAllowedIPs parse the allowed ip's and return a net.IP.
-// It will return nil if it's in wrong format
-func (p *Peer) ParseAllowedIPs() net.IP {
-	ipaddr, _, _ := net.ParseCIDR(p.Spec.AllowedIPs)
-	return ipaddr
+// ParseAllowedIPs parse all the allowed ip's of a peer.
+// The addresses in wrong format are ignored
+func (p *Peer) ParseAllowedIPs() []net.IP {
+	var ips []net.IP
+	for _, address := range SplitAddresses(p.Spec.AllowedIPs) {
+		if ipaddr, _, err := net.ParseCIDR(address); err == nil {
+			ips = append(ips, ipaddr)
+		}
+	}
+	return ips
+}
+
+// GetAllowedIPs returns the addresses and the subnets routed through the peer
+func (p *Peer) GetAllowedIPs() []string {
+	return append(SplitAddresses(p.Spec.AllowedIPs), p.Spec.Subnets...)
+}
+
+// GetClientRoutes returns the networks routed through the tunnel by the client of the peer,
+// the routes of the peer override the ones of the server.
+func (p *Peer) GetClientRoutes(wgsc *WireguardServerConfig) string {
+	switch {
+	case len(p.Spec.ClientRoutes) > 0:
+		return strings.Join(p.Spec.ClientRoutes, ", ")
+	case len(wgsc.ClientRoutes) > 0:
+		return strings.Join(wgsc.ClientRoutes, ", ")
+	}
+	return PeerDefaultClientRoutes
+}
+
+// GetAddresses returns the list of addresses of the server
+func (w *WireguardServerConfig) GetAddresses() []string {
+	return SplitAddresses(w.Address)
 }
 
 // NewKey creates a Key from an existing byte slice.  The byte slice must be
@@ -240,6 +393,18 @@ func GeneratePrivateKey() (Key, error) {
 	return key, nil
 }
 
+// SplitAddresses split a comma separated list of addresses
+// e.g.: 10.100.0.1/24, fd00::1/64
+func SplitAddresses(s string) []string {
+	var addresses []string
+	for _, address := range strings.Split(s, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
 // ParseCIDR tries to parse a ipv4 or ipv6, returns nil otherwise
 func ParseCIDR(s string) *net.IPNet {
 	_, ipnet, _ := net.ParseCIDR(s)
@@ -260,15 +425,15 @@ func HandleTemplates(text string, file io.Writer, data interface{}) error {
 }
 
 // ParseWireguardServerConfigTemplate parse to []byte the wireguard server config template
-func (w *WireguardServerConfig) ParseWireguardServerConfigTemplate(cipherKey string) ([]byte, error) {
+func (w *WireguardServerConfig) ParseWireguardServerConfigTemplate(cipherKeys ...string) ([]byte, error) {
 	var buf bytes.Buffer
-	privKey, err := w.DecryptPrivateKey(cipherKey)
+	privKey, err := w.DecryptPrivateKey(cipherKeys...)
 	if err != nil {
 		return nil, err
 	}
 	if err := HandleTemplates(string(templateWireguardServerConfig), &buf, map[string]interface{}{
 		"PrivateKey": privKey.String(),
-		"Address":    w.Address,
+		"Address":    strings.Join(w.GetAddresses(), ", "),
 		"ListenPort": w.ListenPort,
 		"PostUp":     w.PostUp,
 		"PostDown":   w.PostDown,
@@ -278,17 +443,157 @@ func (w *WireguardServerConfig) ParseWireguardServerConfigTemplate(cipherKey str
 	return buf.Bytes(), nil
 }
 
-// DecryptPrivateKey decrypt the private key using the given cipher key
-func (w *WireguardServerConfig) DecryptPrivateKey(cipherKey string) (Key, error) {
+// EncryptPrivateKey encrypts a private key prefixing it with the id of the cipher key
+func EncryptPrivateKey(privKey Key, cipherKey string) (string, error) {
 	cipher, err := util.NewAESCipherKey(cipherKey)
 	if err != nil {
-		return Key{}, fmt.Errorf("failed creating cipher key: %v", err)
+		return "", fmt.Errorf("failed creating cipher key: %v", err)
+	}
+	encPrivKey, err := cipher.EncryptMessageWithID(privKey.String())
+	if err != nil {
+		return "", fmt.Errorf("failed encrypting private key: %v", err)
+	}
+	return encPrivKey, nil
+}
+
+// DecryptPrivateKey decrypt the active private key using the given cipher keys.
+// The key matching the id of the encrypted key is used, keys without an id
+// are tried in order.
+func (w *WireguardServerConfig) DecryptPrivateKey(cipherKeys ...string) (Key, error) {
+	encPrivKey, _ := w.GetActiveKeys(time.Now().UTC())
+	return decryptKey(encPrivKey, cipherKeys)
+}
+
+func decryptKey(encPrivKey string, cipherKeys []string) (Key, error) {
+	keyID, msg := util.SplitCipherKeyID(encPrivKey)
+	err := fmt.Errorf("missing cipher key")
+	for _, cipherKey := range cipherKeys {
+		// an empty key would generate a random one
+		if cipherKey == "" {
+			continue
+		}
+		cipher, cerr := util.NewAESCipherKey(cipherKey)
+		if cerr != nil {
+			return Key{}, fmt.Errorf("failed creating cipher key: %v", cerr)
+		}
+		if keyID != "" && cipher.ID() != keyID {
+			err = fmt.Errorf("cipher key with id %q not found", keyID)
+			continue
+		}
+		privKeyEncoded, derr := cipher.DecryptMessage(msg)
+		if derr != nil {
+			err = fmt.Errorf("failed decrypting private key: %v", derr)
+			continue
+		}
+		privKey, perr := ParseKey(privKeyEncoded)
+		if perr != nil {
+			err = fmt.Errorf("failed decrypting private key: %v", perr)
+			continue
+		}
+		return privKey, nil
+	}
+	return Key{}, err
+}
+
+// ReEncryptPrivateKeys decrypts the private keys of the server, including the one
+// of a pending key rotation, and encrypts them again with the new cipher key.
+// Each key is validated by decrypting it again before replacing the old one.
+func (w *WireguardServerConfig) ReEncryptPrivateKeys(oldCipherKey, newCipherKey string) error {
+	for _, encPrivKey := range w.encryptedPrivateKeys() {
+		newEncPrivKey, err := reEncryptKey(*encPrivKey, oldCipherKey, newCipherKey)
+		if err != nil {
+			return err
+		}
+		*encPrivKey = newEncPrivKey
+	}
+	return nil
+}
+
+// MigrateEncryption re-encrypts the private keys stored in older formats using
+// the current one, it returns true if any of the keys was migrated.
+func (w *WireguardServerConfig) MigrateEncryption(cipherKey string) (bool, error) {
+	var migrated bool
+	for _, encPrivKey := range w.encryptedPrivateKeys() {
+		keyID, _ := util.SplitCipherKeyID(*encPrivKey)
+		if keyID != "" && util.GetCipherVersion(*encPrivKey) == util.CipherVersionGCM {
+			continue
+		}
+		newEncPrivKey, err := reEncryptKey(*encPrivKey, cipherKey, cipherKey)
+		if err != nil {
+			return false, err
+		}
+		*encPrivKey = newEncPrivKey
+		migrated = true
+	}
+	return migrated, nil
+}
+
+func (w *WireguardServerConfig) encryptedPrivateKeys() []*string {
+	encPrivKeys := []*string{&w.EncryptedPrivateKey}
+	if w.KeyRotation != nil {
+		encPrivKeys = append(encPrivKeys, &w.KeyRotation.EncryptedPrivateKey)
+	}
+	return encPrivKeys
+}
+
+func reEncryptKey(encPrivKey, oldCipherKey, newCipherKey string) (string, error) {
+	privKey, err := decryptKey(encPrivKey, []string{oldCipherKey})
+	if err != nil {
+		return "", err
 	}
-	privKeyEncoded, err := cipher.DecryptMessage(w.EncryptedPrivateKey)
+	newEncPrivKey, err := EncryptPrivateKey(privKey, newCipherKey)
 	if err != nil {
-		return Key{}, fmt.Errorf("failed decrypting private key: %v", err)
+		return "", err
+	}
+	got, err := decryptKey(newEncPrivKey, []string{newCipherKey})
+	if err != nil || got != privKey {
+		return "", fmt.Errorf("failed validating the re-encrypted private key: %v", err)
+	}
+	return newEncPrivKey, nil
+}
+
+// GetActiveKeys returns the encrypted private key and the public key which the
+// server must use at the given time, taking into account a pending key rotation
+func (w *WireguardServerConfig) GetActiveKeys(now time.Time) (string, *Key) {
+	if w.KeyRotation != nil && w.KeyRotation.IsSwitched(now) {
+		return w.KeyRotation.EncryptedPrivateKey, w.KeyRotation.PublicKey
+	}
+	return w.EncryptedPrivateKey, w.PublicKey
+}
+
+// GetActivePublicKey returns the public key which the clients must use at the given time
+func (w *WireguardServerConfig) GetActivePublicKey(now time.Time) *Key {
+	_, pubKey := w.GetActiveKeys(now)
+	return pubKey
+}
+
+// CompleteKeyRotation replaces the server keys with the rotated ones,
+// it returns false if the rotation wasn't switched yet.
+func (w *WireguardServerConfig) CompleteKeyRotation(now time.Time) bool {
+	if w.KeyRotation == nil || !w.KeyRotation.IsSwitched(now) {
+		return false
 	}
-	return ParseKey(privKeyEncoded)
+	w.EncryptedPrivateKey = w.KeyRotation.EncryptedPrivateKey
+	w.PublicKey = w.KeyRotation.PublicKey
+	w.KeyRotation = nil
+	return true
+}
+
+// GetSwitchTime returns the time when the new keys start being used
+func (r *ServerKeyRotation) GetSwitchTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, r.SwitchAt)
+	return t
+}
+
+// IsSwitched verify if the server is using the new keys at the given time
+func (r *ServerKeyRotation) IsSwitched(now time.Time) bool {
+	return !now.Before(r.GetSwitchTime())
+}
+
+// IsComplete verify if the grace period of the previous keys has ended
+func (r *ServerKeyRotation) IsComplete(now time.Time) bool {
+	grace, _ := time.ParseDuration(r.GracePeriod)
+	return !now.Before(r.GetSwitchTime().Add(grace))
 }
 
 // ParseWireguardClientConfigTemplate parse to []byte the wireguard client config template
@@ -2,8 +2,10 @@ package api
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sandromello/wgadmin/pkg/util"
@@ -151,6 +153,191 @@ func TestBadKeys(t *testing.T) {
 	}
 }
 
+func TestServerKeyRotation(t *testing.T) {
+	oldPriv, _ := GeneratePrivateKey()
+	newPriv, _ := GeneratePrivateKey()
+	oldPub, newPub := oldPriv.PublicKey(), newPriv.PublicKey()
+	switchAt := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	w := &WireguardServerConfig{
+		EncryptedPrivateKey: "old",
+		PublicKey:           &oldPub,
+		KeyRotation: &ServerKeyRotation{
+			EncryptedPrivateKey: "new",
+			PublicKey:           &newPub,
+			SwitchAt:            switchAt.Format(time.RFC3339),
+			GracePeriod:         "24h",
+		},
+	}
+	for _, tt := range []struct {
+		name       string
+		now        time.Time
+		expKey     string
+		expPubKey  *Key
+		isComplete bool
+	}{
+		{"before switch", switchAt.Add(-time.Second), "old", &oldPub, false},
+		{"on switch", switchAt, "new", &newPub, false},
+		{"after grace period", switchAt.Add(24 * time.Hour), "new", &newPub, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			encKey, pubKey := w.GetActiveKeys(tt.now)
+			if encKey != tt.expKey || pubKey != tt.expPubKey {
+				t.Fatalf("unexpected active keys, got %v/%v", encKey, pubKey)
+			}
+			if w.KeyRotation.IsComplete(tt.now) != tt.isComplete {
+				t.Fatalf("expected complete to be %v", tt.isComplete)
+			}
+		})
+	}
+	if w.CompleteKeyRotation(switchAt.Add(-time.Second)) {
+		t.Fatal("expected to not complete a rotation before the switch")
+	}
+	if !w.CompleteKeyRotation(switchAt) || w.EncryptedPrivateKey != "new" || w.PublicKey != &newPub || w.KeyRotation != nil {
+		t.Fatalf("unexpected server keys after completing the rotation: %#v", w)
+	}
+}
+
+func TestReEncryptPrivateKeys(t *testing.T) {
+	oldKey, _ := util.NewAESCipherKey("")
+	newKey, _ := util.NewAESCipherKey("")
+	privKey, _ := GeneratePrivateKey()
+	rotationPrivKey, _ := GeneratePrivateKey()
+	// keys encrypted before the key id was introduced must be rotated as well
+	encPrivKey, err := oldKey.EncryptMessage(privKey.String())
+	if err != nil {
+		t.Fatalf("failed encrypting private key: %v", err)
+	}
+	encRotationPrivKey, err := EncryptPrivateKey(rotationPrivKey, oldKey.String())
+	if err != nil {
+		t.Fatalf("failed encrypting private key: %v", err)
+	}
+	w := &WireguardServerConfig{
+		EncryptedPrivateKey: encPrivKey,
+		KeyRotation: &ServerKeyRotation{
+			EncryptedPrivateKey: encRotationPrivKey,
+			SwitchAt:            time.Now().UTC().Add(time.Hour).Format(time.RFC3339),
+		},
+	}
+	if err := w.ReEncryptPrivateKeys(newKey.String(), oldKey.String()); err == nil {
+		t.Fatal("expected an error re-encrypting with the wrong cipher key, but none occurred")
+	}
+	if err := w.ReEncryptPrivateKeys(oldKey.String(), newKey.String()); err != nil {
+		t.Fatalf("failed re-encrypting private keys: %v", err)
+	}
+	for _, encKey := range []string{w.EncryptedPrivateKey, w.KeyRotation.EncryptedPrivateKey} {
+		if keyID, _ := util.SplitCipherKeyID(encKey); keyID != newKey.ID() {
+			t.Fatalf("expected key id %q, got %q", newKey.ID(), keyID)
+		}
+	}
+	// daemons holding both keys must decrypt with any order
+	for _, keys := range [][]string{
+		{newKey.String()},
+		{oldKey.String(), newKey.String()},
+	} {
+		got, err := w.DecryptPrivateKey(keys...)
+		if err != nil {
+			t.Fatalf("failed decrypting private key: %v", err)
+		}
+		if got != privKey {
+			t.Fatalf("unexpected private key, got %v", got)
+		}
+	}
+	if _, err := w.DecryptPrivateKey(oldKey.String()); err == nil {
+		t.Fatal("expected an error decrypting with the old cipher key, but none occurred")
+	}
+}
+
+func TestMigrateEncryption(t *testing.T) {
+	// encrypted with the legacy AES-CFB format
+	cipherKey := "5lBLo84m7q1Aq4cBPZ7bqUtMMNHjnGtHptB5CFO5tpg="
+	legacyEncPrivKey := "bloXddo2or73Zg8vlG8xb2/rjR8gCEdI5d1jRuJW3uZdWGmVkNaqnWPAtT/4DbRyBFe42OQpaQIcV2z8J7oRNw=="
+	expPrivKey, _ := ParseKey("GNyaar5SFUOf3emHLP+dhyTTKT6zXlmkZB0bg2uuFHQ=")
+
+	w := &WireguardServerConfig{EncryptedPrivateKey: legacyEncPrivKey}
+	if util.GetCipherVersion(w.EncryptedPrivateKey) != util.CipherVersionCFB {
+		t.Fatalf("expected legacy version, got %q", util.GetCipherVersion(w.EncryptedPrivateKey))
+	}
+	migrated, err := w.MigrateEncryption(cipherKey)
+	if err != nil || !migrated {
+		t.Fatalf("failed migrating encryption, migrated=%v, err=%v", migrated, err)
+	}
+	if keyID, _ := util.SplitCipherKeyID(w.EncryptedPrivateKey); keyID != "2fd98024" {
+		t.Fatalf("unexpected key id %q", keyID)
+	}
+	if v := util.GetCipherVersion(w.EncryptedPrivateKey); v != util.CipherVersionGCM {
+		t.Fatalf("expected version %q, got %q", util.CipherVersionGCM, v)
+	}
+	got, err := w.DecryptPrivateKey(cipherKey)
+	if err != nil {
+		t.Fatalf("failed decrypting private key: %v", err)
+	}
+	if got != expPrivKey {
+		t.Fatalf("unexpected private key, got %v", got)
+	}
+	if migrated, err := w.MigrateEncryption(cipherKey); err != nil || migrated {
+		t.Fatalf("expected to not migrate twice, migrated=%v, err=%v", migrated, err)
+	}
+
+	// tampered messages or wrong keys must fail
+	ck, _ := util.NewAESCipherKey(cipherKey)
+	encMsg, _ := ck.EncryptMessage(expPrivKey.String())
+	tampered := []byte(encMsg)
+	tampered[len(tampered)-5] ^= 1
+	if _, err := ck.DecryptMessage(string(tampered)); err == nil {
+		t.Fatal("expected an error decrypting a tampered message, but none occurred")
+	}
+	wrongKey, _ := util.NewAESCipherKey("")
+	if _, err := wrongKey.DecryptMessage(encMsg); err == nil {
+		t.Fatal("expected an error decrypting with a wrong key, but none occurred")
+	}
+}
+
+func TestPeerGetClientRoutes(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		serverRoutes []string
+		peerRoutes   []string
+		want         string
+	}{
+		{name: "full tunnel by default", want: PeerDefaultClientRoutes},
+		{name: "server routes", serverRoutes: []string{"10.100.0.0/24", "172.16.0.0/12"}, want: "10.100.0.0/24, 172.16.0.0/12"},
+		{name: "peer overrides server", serverRoutes: []string{"10.100.0.0/24"}, peerRoutes: []string{"10.0.0.0/8"}, want: "10.0.0.0/8"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Peer{Spec: PeerSpec{ClientRoutes: tt.peerRoutes}}
+			got := p.GetClientRoutes(&WireguardServerConfig{ClientRoutes: tt.serverRoutes})
+			if got != tt.want {
+				t.Fatalf("unexpected client routes, want %q got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSessionKeyPair(t *testing.T) {
+	b64 := func(size int) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), size))
+	}
+	for _, tt := range []struct {
+		key     SessionKey
+		isValid bool
+	}{
+		{SessionKey{HashKey: b64(64), BlockKey: b64(32)}, true},
+		{SessionKey{HashKey: b64(32), BlockKey: b64(16)}, true},
+		{SessionKey{HashKey: b64(32)}, true},
+		{SessionKey{HashKey: "", BlockKey: b64(32)}, false},
+		{SessionKey{HashKey: b64(64), BlockKey: b64(20)}, false},
+		{SessionKey{HashKey: "not base64!"}, false},
+	} {
+		_, _, err := tt.key.KeyPair()
+		if tt.isValid && err != nil {
+			t.Fatalf("failed decoding session key %#v: %v", tt.key, err)
+		}
+		if !tt.isValid && err == nil {
+			t.Fatalf("expected an error decoding session key %#v, but none occurred", tt.key)
+		}
+	}
+}
+
 // func TestSerializeWireguardServerConfig(t *testing.T) {
 // 	priv, err := GeneratePrivateKey()
 // 	if err != nil {
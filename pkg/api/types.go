@@ -7,15 +7,57 @@ import (
 
 const PeerDefaultMTU string = "1280"
 
+// PeerDefaultStatsInterval is the minimum interval between writes of the peer stats
+const PeerDefaultStatsInterval = 10 * time.Minute
+
+// PeerDefaultClientRoutes routes all the traffic of the clients through the tunnel
+const PeerDefaultClientRoutes string = "0.0.0.0/0, ::/0"
+
 // WebApp holds information about the webapp server
 type WebApp struct {
-	HTTPPort                     string      `json:"httpPort"`
-	AllowedDomains               []string    `json:"allowedDomains"`
-	PageConfig                   *PageConfig `json:"pageConfig"`
-	TLSKeyFile                   string      `json:"tlsKeyFile"`
-	TLSCertFile                  string      `json:"tlsCertFile"`
-	GoogleApplicationCredentials string      `json:"googleApplicationCredentials"`
-	GCSBucketName                string      `json:"gcsBucketName"`
+	HTTPPort                     string        `json:"httpPort"`
+	AllowedDomains               []string      `json:"allowedDomains"`
+	PageConfig                   *PageConfig   `json:"pageConfig"`
+	TLSKeyFile                   string        `json:"tlsKeyFile"`
+	TLSCertFile                  string        `json:"tlsCertFile"`
+	GoogleApplicationCredentials string        `json:"googleApplicationCredentials"`
+	GCSBucketName                string        `json:"gcsBucketName"`
+	Remote                       *RemoteConfig `json:"remote"`
+	OIDC                         *OIDCConfig   `json:"oidc"`
+	// SessionKeys authenticate and encrypt the session cookies, the first
+	// key signs new sessions and the others are only used to verify them.
+	SessionKeys []SessionKey `json:"sessionKeys"`
+	// SessionKeysFile is a file with a list of session keys,
+	// they are appended to the sessionKeys attribute.
+	SessionKeysFile string `json:"sessionKeysFile"`
+}
+
+// SessionKey is a pair of keys used by the cookie store of the webapp
+type SessionKey struct {
+	// HashKey is a base64 encoded key used to authenticate the cookies,
+	// 32 or 64 bytes are recommended.
+	HashKey string `json:"hashKey"`
+	// BlockKey is a base64 encoded key used to encrypt the cookies,
+	// it must have 16, 24 or 32 bytes. It's optional.
+	BlockKey string `json:"blockKey,omitempty"`
+}
+
+// OIDCConfig configures an OpenID Connect provider to authenticate the users
+// of the webapp, it replaces the Google Sign-In when it's set.
+type OIDCConfig struct {
+	// IssuerURL is used to discover the endpoints of the provider
+	IssuerURL    string   `json:"issuerURL"`
+	ClientID     string   `json:"clientID"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectURL"`
+	Scopes       []string `json:"scopes"`
+	// EmailClaim is the claim of the id token identifying the user
+	EmailClaim string `json:"emailClaim"`
+	// GroupsClaim is the claim of the id token with the groups of the user
+	GroupsClaim string `json:"groupsClaim"`
+	// AllowedGroups restricts the users allowed to sign in,
+	// an empty list allows any group.
+	AllowedGroups []string `json:"allowedGroups"`
 }
 
 // PageConfig is used to configure the content of the webapp
@@ -28,6 +70,41 @@ type PageConfig struct {
 	TemplatePath      string `json:"templatePath"`
 	Title             string `json:"title"`
 	NavBarLink        string `json:"navbarLink"`
+	// GoogleJWKSURL overrides the url of the keys used to verify the id tokens
+	GoogleJWKSURL string `json:"googleJWKSURL"`
+}
+
+// RemoteBackendType indicates where the database is persisted
+type RemoteBackendType string
+
+const (
+	// RemoteBackendGCS stores the database in a Google Cloud Storage bucket
+	RemoteBackendGCS RemoteBackendType = "gcs"
+	// RemoteBackendS3 stores the database in an S3 compatible bucket
+	RemoteBackendS3 RemoteBackendType = "s3"
+	// RemoteBackendLocal stores the database in a local (or mounted) directory
+	RemoteBackendLocal RemoteBackendType = "local"
+)
+
+// RemoteConfig configures the backend used to fetch and upload the database
+type RemoteConfig struct {
+	Type       RemoteBackendType `json:"type"`
+	BucketName string            `json:"bucketName"`
+	ObjectName string            `json:"objectName"`
+	// Path is the directory holding the database when using the local backend
+	Path string    `json:"path"`
+	S3   *S3Config `json:"s3"`
+}
+
+// S3Config holds the options of an S3 compatible backend,
+// credentials fallback to the default AWS chain when empty
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"accessKeyID"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	ForcePathStyle  bool   `json:"forcePathStyle"`
+	DisableSSL      bool   `json:"disableSSL"`
 }
 
 // Duration a custom time.Duration
@@ -42,10 +119,24 @@ type Daemon interface {
 // ServerConfig is all the required configuration to run the
 // daemons that sync peers and servers
 type ServerConfig struct {
-	Name         string       `json:"name"`
-	BucketName   string       `json:"bucketName"`
-	ServerDaemon ServerDaemon `json:"server"`
-	PeerDaemon   PeerDaemon   `json:"peer"`
+	Name         string        `json:"name"`
+	BucketName   string        `json:"bucketName"`
+	Remote       *RemoteConfig `json:"remote"`
+	ServerDaemon ServerDaemon  `json:"server"`
+	PeerDaemon   PeerDaemon    `json:"peer"`
+}
+
+// SyncPolicy configures the interval between the syncs of a daemon
+type SyncPolicy struct {
+	// Jitter randomizes each interval by up to the given fraction of the
+	// syncTime, e.g.: 0.1 spreads the syncs between 90% and 110% of it.
+	Jitter float64 `json:"jitter,omitempty"`
+	// MaxBackoff is the limit of the interval after consecutive failures, the
+	// interval doubles on each failure. The backoff is disabled when it's empty.
+	MaxBackoff Duration `json:"maxBackoff,omitempty"`
+	// MaxFailures stops the daemon with an error after the given
+	// consecutive failures, zero means it never stops.
+	MaxFailures int `json:"maxFailures,omitempty"`
 }
 
 // PeerDaemon is a configuration to tell how to synchronize and configure peers
@@ -54,6 +145,19 @@ type PeerDaemon struct {
 	SystemdPath   string   `json:"systemdPath"`
 	SyncTime      Duration `json:"syncTime"`
 	InterfaceName string   `json:"interfaceName"`
+	// Backend manages the peers of the interface: auto, netlink or exec.
+	// The default (auto) uses netlink and fallbacks to the wg tool.
+	Backend string `json:"backend"`
+	// ListenAddress of the http server exposing the metrics and the health
+	// endpoints, e.g.: 127.0.0.1:9586. The server is disabled when it's empty.
+	ListenAddress string `json:"listenAddress,omitempty"`
+	// StaleThreshold is the age of the last successful reconcile which
+	// fails the readiness, it defaults to three times the syncTime.
+	StaleThreshold Duration `json:"staleThreshold,omitempty"`
+	// StatsInterval is the minimum interval between writes of the peer stats
+	// to the store when only their counters changed, it defaults to 10m.
+	StatsInterval Duration `json:"statsInterval,omitempty"`
+	SyncPolicy
 }
 
 // ServerDaemon is a configuration to tell how to synchronize and configure a server
@@ -64,6 +168,18 @@ type ServerDaemon struct {
 	CipherKey   string   `json:"cipherKey"`
 	ConfigPath  string   `json:"configPath"`
 	ConfigFile  string   `json:"configFile"`
+	// CipherKeys are additional keys used to decrypt the server private key,
+	// it allows rolling out a new cipher key before rotating it.
+	CipherKeys []string `json:"cipherKeys,omitempty"`
+	// Backend configures the running interface: auto, netlink or exec
+	Backend string `json:"backend"`
+	// ListenAddress of the http server exposing the metrics and the health
+	// endpoints, e.g.: 127.0.0.1:9585. The server is disabled when it's empty.
+	ListenAddress string `json:"listenAddress,omitempty"`
+	// StaleThreshold is the age of the last successful reconcile which
+	// fails the readiness, it defaults to three times the syncTime.
+	StaleThreshold Duration `json:"staleThreshold,omitempty"`
+	SyncPolicy
 }
 
 // KeyLen is the expected key length for a WireGuard key.
@@ -76,10 +192,14 @@ type Key [KeyLen]byte
 
 // Metadata common attributes to all objects
 type Metadata struct {
-	UID string `json:"uid"`
+	UID    string            `json:"uid"`
+	Labels map[string]string `json:"labels,omitempty"`
 
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
+	// DeletedAt and DeletedBy are set when the object is archived
+	DeletedAt string `json:"deletedAt,omitempty"`
+	DeletedBy string `json:"deletedBy,omitempty"`
 }
 
 // PeerPhase indicates in which state a peer is
@@ -120,6 +240,8 @@ const (
 type WireguardServerConfig struct {
 	Metadata `json:",inline"`
 
+	// Address is a comma separated list of the server addresses,
+	// IPv4 and IPv6 networks are allowed, e.g.: 10.100.0.1/24, fd00::1/64
 	Address             string   `json:"address"`
 	ListenPort          int      `json:"listenPort"`
 	EncryptedPrivateKey string   `json:"encryptedPrivateKey"`
@@ -127,6 +249,34 @@ type WireguardServerConfig struct {
 	PostUp              []string `json:"postUp"`
 	PostDown            []string `json:"postDown"`
 	PublicEndpoint      string   `json:"publicEndpoint"`
+	// Reserved are addresses or networks never allocated to peers, e.g.: 10.100.0.53, 10.100.0.240/28
+	Reserved []string `json:"reserved,omitempty"`
+	// Pools are ranges set apart from the automatic allocation,
+	// the peers are allocated from them only when the pool is requested
+	Pools []AddressPool `json:"pools,omitempty"`
+	// RouteSubnets makes the peer daemon route the subnets of the active peers through the interface
+	RouteSubnets bool `json:"routeSubnets,omitempty"`
+	// ClientRoutes are the networks routed through the tunnel by the clients,
+	// defaults to all the traffic. Set internal ranges for a split tunnel.
+	ClientRoutes []string `json:"clientRoutes,omitempty"`
+	// KeyRotation is set when the server keys are being replaced
+	KeyRotation *ServerKeyRotation `json:"keyRotation,omitempty"`
+}
+
+// AddressPool is a named range of addresses of a server
+type AddressPool struct {
+	Name string `json:"name"`
+	// Address is a comma separated list of networks, e.g.: 10.100.1.0/24, fd00::1:0/112
+	Address string `json:"address"`
+}
+
+// ServerKeyRotation holds a new key pair which replaces the server keys at SwitchAt.
+// The previous keys are kept until the grace period ends, allowing a rollback.
+type ServerKeyRotation struct {
+	EncryptedPrivateKey string `json:"encryptedPrivateKey"`
+	PublicKey           *Key   `json:"publicKey"`
+	SwitchAt            string `json:"switchAt"`
+	GracePeriod         string `json:"gracePeriod"`
 }
 
 // Peer is a section of peer in a wg server config file
@@ -137,7 +287,8 @@ type Peer struct {
 	Status PeerStatus `json:"status"`
 }
 
-// PeerSpec main configuration of a peer
+// PeerSpec main configuration of a peer,
+// the AllowedIPs is a comma separated list of the peer addresses.
 type PeerSpec struct {
 	PersistentPublicKey *Key                 `json:"persistentPublicKey"`
 	AllowedIPs          string               `json:"allowedIPs"`
@@ -145,12 +296,33 @@ type PeerSpec struct {
 	ExpireDuration      string               `json:"expireDuration"`
 	ClientMTU           string               `json:"clientMTU"`
 	Blocked             bool                 `json:"blocked"`
+	// Subnets are networks routed through the peer (site-to-site),
+	// e.g.: the LAN of a branch office
+	Subnets []string `json:"subnets,omitempty"`
+	// ClientRoutes overrides the client routes of the server for this peer
+	ClientRoutes []string `json:"clientRoutes,omitempty"`
 }
 
 // PeerStatus hold status of a peer
 type PeerStatus struct {
 	SecretValue string `json:"secretValue"`
 	PublicKey   *Key   `json:"publicKey"`
+	// RenewConfig indicates that the client config must be downloaded
+	// again because the server keys were rotated
+	RenewConfig bool `json:"renewConfig,omitempty"`
+	// Stats is the runtime state of the peer collected by the peer daemon
+	Stats *PeerStats `json:"stats,omitempty"`
+}
+
+// PeerStats is the runtime state of a peer in the wireguard server
+type PeerStats struct {
+	// LastHandshake is empty if the peer never connected
+	LastHandshake string `json:"lastHandshake,omitempty"`
+	TransferRx    int64  `json:"transferRx"`
+	TransferTx    int64  `json:"transferTx"`
+	// Endpoint is the last observed address of the peer
+	Endpoint  string `json:"endpoint,omitempty"`
+	UpdatedAt string `json:"updatedAt"`
 }
 
 // PeerClientConfig represents a Peer section on a client wireguard config
@@ -1,16 +1,22 @@
 package cli
 
 import (
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/google/uuid"
 	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/health"
+	"github.com/sandromello/wgadmin/pkg/metrics"
 	storeclient "github.com/sandromello/wgadmin/pkg/store/client"
 	"github.com/sandromello/wgadmin/pkg/systemd"
 	"github.com/sandromello/wgadmin/pkg/wgtools"
@@ -25,26 +31,51 @@ func hashFromByte(data []byte) string {
 }
 
 // func fetchState(server, configFile, cipherKey string) ([]byte, []byte, error) {
-func fetchState(sc *api.ServerConfig) ([]byte, []byte, error) {
+func fetchState(sc *api.ServerConfig) ([]byte, []byte, *api.WireguardServerConfig, error) {
 	localConfigData, err := ioutil.ReadFile(sc.GetWireguardConfigFile())
 	if err != nil && !os.IsNotExist(err) {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	// TODO: set a timeout when opening: bolt.Options{Timeout: Duration}
-	client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
+	client, err := newStoreClient()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer client.Close()
 	wgsc, err := client.WireguardServerConfig().Get(sc.Name)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	if wgsc == nil {
-		return nil, nil, fmt.Errorf("wireguard server %q not found", sc.Name)
+		return nil, nil, nil, fmt.Errorf("wireguard server %q not found", sc.Name)
 	}
-	remoteConfigData, err := wgsc.ParseWireguardServerConfigTemplate(sc.ServerDaemon.CipherKey)
-	return localConfigData, remoteConfigData, err
+	remoteConfigData, err := wgsc.ParseWireguardServerConfigTemplate(sc.ServerDaemon.GetCipherKeys()...)
+	return localConfigData, remoteConfigData, wgsc, err
+}
+
+// completeKeyRotation discards the previous keys of a server once the grace period
+// of its key rotation ends, the rotation can't be cancelled afterwards.
+func completeKeyRotation(logf *log.Entry, wgsc *api.WireguardServerConfig) error {
+	now := time.Now().UTC()
+	if wgsc.KeyRotation == nil || !wgsc.KeyRotation.IsComplete(now) {
+		return nil
+	}
+	if err := updateStore(func(client storeclient.Client) error {
+		current, err := client.WireguardServerConfig().Get(wgsc.UID)
+		if err != nil {
+			return err
+		}
+		// the rotation was cancelled or completed meanwhile
+		if current == nil || current.KeyRotation == nil || !current.KeyRotation.IsComplete(now) {
+			return nil
+		}
+		current.CompleteKeyRotation(now)
+		return client.WireguardServerConfig().Update(current)
+	}); err != nil {
+		return fmt.Errorf("failed completing the key rotation: %v", err)
+	}
+	logf.Infof("Grace period of the key rotation ended, the previous keys of server %s were discarded", wgsc.UID)
+	return nil
 }
 
 func checkDirty(configFile string) (isDirty bool, errMsg error) {
@@ -74,30 +105,97 @@ func setDirty(isDirty bool, configFile string) (errMsg error) {
 	return errMsg
 }
 
-func conciliateState(configFile string, data []byte) ([]byte, error) {
+// planInterfaceChanges compares the config on disk with the desired one,
+// a missing or dirty config is restarted since the interface might be down.
+func planInterfaceChanges(localData, remoteData []byte, isDirty bool) (wgtools.InterfaceChanges, *wgtools.InterfaceConfig, error) {
+	changes := wgtools.InterfaceChanges{Restart: true}
+	desired, err := wgtools.ParseInterfaceConfig(remoteData)
+	if err != nil {
+		return changes, nil, fmt.Errorf("failed parsing config: %v", err)
+	}
+	if current, err := wgtools.ParseInterfaceConfig(localData); err == nil && len(localData) > 0 && !isDirty {
+		changes = wgtools.DiffInterfaceConfig(current, desired)
+	}
+	return changes, desired, nil
+}
+
+// the wg-quick commands and the root check, replaced in tests
+var (
+	wgQuickDown = wgtools.WGQuickDown
+	wgQuickUp   = wgtools.WGQuickUP
+	isRootUser  = wgtools.IsRootUser
+)
+
+// conciliateState writes the desired config and applies it to the running interface.
+// The private key and listen port are changed live, the interface is restarted
+// only if it's dirty or the address or hooks changed. The interface is brought
+// down with the current config, thus its PostDown hooks remove the old rules.
+func conciliateState(logf *log.Entry, wg wgtools.Client, iface, configFile string, localData, remoteData []byte, isDirty bool) ([]byte, error) {
 	if err := setDirty(true, configFile); err != nil {
 		return nil, err
 	}
-	isRoot, err := wgtools.IsRootUser()
+	isRoot, err := isRootUser()
 	if err != nil {
 		return nil, fmt.Errorf("failed veryfing the current user: %v", err)
 	}
 	if !isRoot {
 		return nil, fmt.Errorf("must be run as root user")
 	}
+	changes, desired, err := planInterfaceChanges(localData, remoteData, isDirty)
+	if err != nil {
+		return nil, err
+	}
+	logf.Infof("restart=%v, privatekey=%v, listenport=%v", changes.Restart, changes.PrivateKey, changes.ListenPort)
+	if !changes.Restart && changes.IsLive() {
+		if err := wg.ConfigureInterface(iface, desired); err != nil {
+			logf.Warnf("failed configuring interface %s, restarting it: %v", iface, err)
+			changes.Restart = true
+		}
+	}
+	if changes.Restart && len(localData) > 0 {
+		// TODO: add vagrant for testing locally
+		if stdout, err := wgQuickDown(configFile); err != nil {
+			logf.Warnf("failed bringing down interface %s: %v. %s", iface, err, strings.TrimSpace(string(stdout)))
+		}
+	}
 	// TODO: overwrite the destination configuration with the remote config
-	if err := ioutil.WriteFile(configFile, data, 0700); err != nil {
+	if err := ioutil.WriteFile(configFile, remoteData, 0700); err != nil {
 		return nil, err
 	}
-	// TODO: add vagrant for testing locally
-	wgtools.WGQuickDown(configFile)
-	stdout, err := wgtools.WGQuickUP(configFile)
+	if !changes.Restart {
+		return nil, setDirty(false, configFile)
+	}
+	stdout, err := wgQuickUp(configFile)
 	if err != nil {
 		return stdout, err
 	}
 	return stdout, setDirty(false, configFile)
 }
 
+// serveHTTP starts the http server of a daemon in background exposing the metrics
+// and the health endpoints, it fails if the address can't be bound.
+// An empty address disables it.
+func serveHTTP(addr string, status *health.Status) error {
+	if addr == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed listening on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", status.Healthz)
+	mux.HandleFunc("/readyz", status.Readyz)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Errorf("failed serving http: %v", err)
+		}
+	}()
+	log.Infof("Serving metrics and health endpoints at http://%s", l.Addr())
+	return nil
+}
+
 func parseServerConfigFile(path string) (*api.ServerConfig, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -132,6 +230,184 @@ func InstallDaemons() *cobra.Command {
 	return cmd
 }
 
+// daemonAction is what a daemon does when it receives a signal
+type daemonAction int
+
+const (
+	daemonTerminate daemonAction = iota
+	daemonReload
+	daemonReconcile
+)
+
+// daemon reconciles the state of a server, it's built from the config file
+type daemon struct {
+	name          string
+	server        string
+	syncTime      time.Duration
+	listenAddress string
+	staleAfter    time.Duration
+	policy        api.SyncPolicy
+	remote        *api.RemoteConfig
+	reconcile     func(logf *log.Entry) error
+	close         func() error
+}
+
+// newDaemonFunc builds a daemon from the config file
+type newDaemonFunc func(sc *api.ServerConfig) (*daemon, error)
+
+// loadDaemon builds a daemon from the config file, the remote backend of the
+// daemon is activated by the caller, thus a failed reload keeps the current one.
+func loadDaemon(newDaemon newDaemonFunc) (*daemon, error) {
+	sc, err := parseServerConfigFile(O.ServerConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	remote := sc.GetRemoteConfig()
+	if _, err := storeclient.NewRemoteBackend(remote); err != nil {
+		return nil, fmt.Errorf("failed configuring remote backend: %v", err)
+	}
+	d, err := newDaemon(sc)
+	if err != nil {
+		return nil, err
+	}
+	d.remote = remote
+	return d, nil
+}
+
+// run executes a single reconcile recording its metrics and health,
+// the errors are logged only by control loops.
+func (d *daemon) run(status *health.Status) error {
+	now := time.Now().UTC()
+	logf := log.WithFields(map[string]interface{}{
+		"job":    uuid.New().String()[:6],
+		"server": d.server,
+	})
+	err := d.reconcile(logf)
+	metrics.ObserveReconcile(d.name, now, err)
+	status.Observe(err)
+	if err != nil && d.syncTime == 0 {
+		return err
+	}
+	if err != nil {
+		logf.Error(err)
+	}
+	logf.Infof("Completed in %vs", time.Since(now).Seconds())
+	return err
+}
+
+// runDaemon reconciles every sync time until SIGTERM or SIGINT is received, the in-flight
+// reconcile always finishes before exiting. SIGHUP reloads the config file and SIGUSR1
+// triggers a reconcile immediately. A daemon without sync time reconciles only once.
+// The interval follows the sync policy and it fails after too many consecutive failures.
+func runDaemon(newDaemon newDaemonFunc) error {
+	d, err := loadDaemon(newDaemon)
+	if err != nil {
+		return err
+	}
+	defer func() { d.close() }()
+	// Configuring runtime defaults
+	GlobalRemoteConfig = d.remote
+	status := health.NewStatus(d.staleAfter)
+	if d.syncTime == 0 {
+		return d.run(status)
+	}
+	if err := serveHTTP(d.listenAddress, status); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan struct{}, 1)
+	trigger := make(chan struct{}, 1)
+	sigs := make(chan os.Signal, 1)
+	for sig := range daemonSignals {
+		signal.Notify(sigs, sig)
+	}
+	defer signal.Stop(sigs)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigs:
+				log.Infof("Received signal %v", sig)
+				switch daemonSignals[sig] {
+				case daemonTerminate:
+					cancel()
+				case daemonReload:
+					notify(reload)
+				case daemonReconcile:
+					notify(trigger)
+				}
+			}
+		}
+	}()
+
+	failures := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		// the termination has precedence over pending reloads and reconciles
+		if ctx.Err() != nil {
+			log.Info("Shutting down ...")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+		case <-reload:
+			newD, err := loadDaemon(newDaemon)
+			if err != nil {
+				log.Errorf("failed reloading config, keeping the current one: %v", err)
+				continue
+			}
+			if newD.syncTime == 0 {
+				newD.close()
+				log.Error("failed reloading config, keeping the current one: the syncTime is required by a running daemon")
+				continue
+			}
+			if newD.listenAddress != d.listenAddress || newD.staleAfter != d.staleAfter {
+				log.Warn("Changing the listenAddress or staleThreshold requires a restart")
+			}
+			d.close()
+			d = newD
+			GlobalRemoteConfig = d.remote
+			log.Info("Config reloaded")
+			resetTimer(timer, 0)
+		case <-trigger:
+			resetTimer(timer, 0)
+		case <-timer.C:
+			failures++
+			if err := d.run(status); err == nil {
+				failures = 0
+			}
+			if d.policy.MaxFailures > 0 && failures >= d.policy.MaxFailures {
+				return fmt.Errorf("giving up after %d consecutive failures", failures)
+			}
+			interval := d.policy.NextInterval(api.Duration(d.syncTime), failures)
+			log.Debugf("Next sync in %v", interval)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// notify sends to a channel without blocking, pending notifications are merged
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
 // SyncServerCmd syncronizes server configuration
 func SyncServerCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -140,60 +416,72 @@ func SyncServerCmd() *cobra.Command {
 		SilenceUsage:      true,
 		PersistentPreRunE: PersistentPreRunE,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			sc, err := parseServerConfigFile(O.ServerConfigPath)
-			if err != nil {
-				return err
-			}
-			// Configuring runtime defaults
-			os.Setenv("GCS_BUCKET_NAME", sc.BucketName)
-			if sc.ServerDaemon.CipherKey == "" {
-				sc.ServerDaemon.CipherKey = os.Getenv("CIPHER_KEY")
-				if sc.ServerDaemon.CipherKey == "" {
-					return fmt.Errorf("Cipher Key is not set")
-				}
-			}
-			conciliate := func(logf *log.Entry) error {
-				logf.Infof("Synchronize server %s ...", sc.Name)
-				localData, remoteData, err := fetchState(sc)
-				if err != nil {
-					return err
-				}
-				wireguardConfigFile := sc.GetWireguardConfigFile()
-				isDirty, err := checkDirty(wireguardConfigFile)
-				if err != nil {
-					return err
-				}
-				isConciliateOperation := hashFromByte(localData) != hashFromByte(remoteData)
-				logf.Infof("dirty=%v, conciliate=%v", isDirty, isConciliateOperation)
-				if isConciliateOperation || isDirty {
-					stdout, err := conciliateState(wireguardConfigFile, remoteData)
-					if err != nil {
-						return fmt.Errorf("%v. %v", strings.TrimSuffix(string(stdout), "\n"), err)
-					}
-					logf.Debug(string(stdout))
-				}
-				return nil
-			}
-
-			isControlLoop := sc.ServerDaemon.SyncTime != api.Duration(0)
-			for {
-				now := time.Now().UTC()
-				logf := log.WithField("job", uuid.New().String()[:6])
-				if err := conciliate(logf); err != nil {
-					if !isControlLoop {
-						return err
-					}
-					logf.Error(err)
-				}
-				logf.Infof("Completed in %vs\n", time.Since(now).Seconds())
-				time.Sleep(time.Duration(sc.ServerDaemon.SyncTime))
+			if O.DryRun {
+				return printServerPlan()
 			}
+			return runDaemon(newServerDaemon)
 		},
 	}
 	cmd.Flags().StringVarP(&O.ServerConfigPath, "config-file", "c", "", "The wgadmin config file.")
+	cmd.Flags().BoolVar(&O.DryRun, "dry-run", false, "Print the changes without applying them.")
+	cmd.Flags().StringVarP(&O.Output, "output", "o", "", "Output format of the dry run. One of: json|yaml.")
 	return cmd
 }
 
+// setServerCipherKey defaults the cipher key to the CIPHER_KEY env
+func setServerCipherKey(sc *api.ServerConfig) error {
+	if sc.ServerDaemon.CipherKey == "" {
+		sc.ServerDaemon.CipherKey = os.Getenv("CIPHER_KEY")
+		if sc.ServerDaemon.CipherKey == "" {
+			return fmt.Errorf("Cipher Key is not set")
+		}
+	}
+	return nil
+}
+
+func newServerDaemon(sc *api.ServerConfig) (*daemon, error) {
+	if err := setServerCipherKey(sc); err != nil {
+		return nil, err
+	}
+	wg, err := wgtools.NewClient(wgtools.Backend(sc.ServerDaemon.Backend))
+	if err != nil {
+		return nil, err
+	}
+	iface := sc.GetInterfaceName()
+	conciliate := func(logf *log.Entry) error {
+		logf.Infof("Synchronize server %s ...", sc.Name)
+		localData, remoteData, wgsc, err := fetchState(sc)
+		if err != nil {
+			return err
+		}
+		wireguardConfigFile := sc.GetWireguardConfigFile()
+		isDirty, err := checkDirty(wireguardConfigFile)
+		if err != nil {
+			return err
+		}
+		isConciliateOperation := hashFromByte(localData) != hashFromByte(remoteData)
+		logf.Infof("dirty=%v, conciliate=%v", isDirty, isConciliateOperation)
+		if isConciliateOperation || isDirty {
+			stdout, err := conciliateState(logf, wg, iface, wireguardConfigFile, localData, remoteData, isDirty)
+			if err != nil {
+				return fmt.Errorf("%v. %v", strings.TrimSuffix(string(stdout), "\n"), err)
+			}
+			logf.Debug(string(stdout))
+		}
+		return completeKeyRotation(logf, wgsc)
+	}
+	return &daemon{
+		name:          metrics.DaemonServer,
+		server:        sc.Name,
+		syncTime:      time.Duration(sc.ServerDaemon.SyncTime),
+		listenAddress: sc.ServerDaemon.ListenAddress,
+		staleAfter:    sc.ServerDaemon.GetStaleThreshold(),
+		policy:        sc.ServerDaemon.SyncPolicy,
+		reconcile:     conciliate,
+		close:         wg.Close,
+	}, nil
+}
+
 // SyncPeersCmd synchronize peers configuration
 func SyncPeersCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -202,110 +490,107 @@ func SyncPeersCmd() *cobra.Command {
 		SilenceUsage:      true,
 		PersistentPreRunE: PersistentPreRunE,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Configuring runtime defaults
-			sc, err := parseServerConfigFile(O.ServerConfigPath)
+			if O.DryRun {
+				return printPeerPlan()
+			}
+			return runDaemon(newPeerDaemon)
+		},
+	}
+	cmd.Flags().StringVarP(&O.ServerConfigPath, "config-file", "c", "", "The wgadmin config file.")
+	cmd.Flags().BoolVar(&O.DryRun, "dry-run", false, "Print the changes without applying them.")
+	cmd.Flags().StringVarP(&O.Output, "output", "o", "", "Output format of the dry run. One of: json|yaml.")
+	return cmd
+}
+
+func newPeerDaemon(sc *api.ServerConfig) (*daemon, error) {
+	iface := sc.PeerDaemon.InterfaceName
+	wg, err := wgtools.NewClient(wgtools.Backend(sc.PeerDaemon.Backend))
+	if err != nil {
+		return nil, err
+	}
+	reconciler := wgtools.NewPeerReconciler(wgtools.NewDevice(wg, iface))
+	routeReconciler := wgtools.NewRouteReconciler(wgtools.NewRouter(iface))
+	conciliate := func(logf *log.Entry) error {
+		client, err := newStoreClient()
+		if err != nil {
+			return err
+		}
+		wgsc, err := client.WireguardServerConfig().Get(sc.Name)
+		if err != nil || wgsc == nil {
+			client.Close()
+			return fmt.Errorf("failed fetching server %v, err=%v", sc.Name, err)
+		}
+		desiredPeers, err := client.Peer().ListByServer(sc.Name)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("failed listing peers: %v", err)
+		}
+		// deleted peers are removed as unknown local peers
+		archivedPeers, err := client.Peer().ListArchived(sc.Name)
+		client.Close()
+		if err != nil {
+			return fmt.Errorf("failed listing deleted peers: %v", err)
+		}
+		result, err := reconciler.Reconcile(logf, desiredPeers, archivedPeers)
+		if result != nil {
+			dirty := 0
 			if err != nil {
-				return err
+				dirty = len(result.Changes)
 			}
-			os.Setenv("GCS_BUCKET_NAME", sc.BucketName)
-			iface := sc.PeerDaemon.InterfaceName
-			conciliate := func(logf *log.Entry) error {
-				client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
+			metrics.DirtyPeers.Set(float64(dirty))
+			metrics.DesiredPeers.Set(float64(len(desiredPeers)))
+			metrics.ActualPeers.Set(float64(len(result.Peers)))
+			metrics.SetPeers(desiredPeers, result.Peers)
+		}
+		if err != nil {
+			return err
+		}
+		logf.Infof("Found %v local and %v remote peers, %v change(s)",
+			len(result.Peers), len(desiredPeers), len(result.Changes))
+		// the routes are removed as well when the routing of the subnets is disabled
+		routedPeers := desiredPeers
+		if !wgsc.RouteSubnets {
+			routedPeers = nil
+		}
+		if _, err := routeReconciler.Reconcile(logf, routedPeers); err != nil {
+			return err
+		}
+		stats := wgtools.CollectStats(desiredPeers, result.Peers, sc.PeerDaemon.GetStatsInterval())
+		if len(stats) == 0 {
+			return nil
+		}
+		if err := updateStore(func(c storeclient.Client) error {
+			for uid, s := range stats {
+				peer, err := c.Peer().Get(uid)
 				if err != nil {
 					return err
 				}
-				defer client.Close()
-				// remove any revoked peers found in remote
-				desiredPeers, err := client.Peer().ListByServer(sc.Name)
-				if err != nil {
-					return fmt.Errorf("failed listing peers: %v", err)
-				}
-				dirty := 0
-				for _, peer := range desiredPeers {
-					shouldAutoLock := peer.ShouldAutoLock()
-					logf.Debugf("op=revoke, peer=%v, status=%v, autolock=%v", peer.UID, peer.GetStatus(), shouldAutoLock)
-					if peer.GetStatus() == api.PeerBlocked || peer.GetStatus() == api.PeerActive && shouldAutoLock {
-						logf.Infof("Removing dirty peer %v", peer.UID)
-						stdout, err := wgtools.WGRemovePeer(iface, peer.PublicKeyString())
-						if err != nil {
-							msg := fmt.Sprintf("%v. %v", strings.TrimSuffix(string(stdout), "\n"), err)
-							logf.Error(msg)
-							dirty++
-						}
-					}
-				}
-				// check if the current peers is consistent with the remote state
-				currentPeers, err := wgtools.WGShowPeers(iface)
-				if err != nil {
-					return fmt.Errorf("failed listing wireguard peers: %v", err)
-				}
-				for _, pubkey := range currentPeers {
-					logf.Debugf("op=conciliate, peer=%s", pubkey)
-					cur, err := client.Peer().SearchByPubKey(sc.Name, pubkey)
-					if err != nil {
-						return fmt.Errorf("failed retrieving peer from store: %v", err)
-					}
-					if cur == nil {
-						logf.Infof("Removing local peer %s", pubkey)
-						stdout, err := wgtools.WGRemovePeer(iface, pubkey)
-						if err != nil {
-							msg := fmt.Sprintf("%v. %v", strings.TrimSuffix(string(stdout), "\n"), err)
-							logf.Error(msg)
-							dirty++
-						}
-					}
-				}
-
-				currentPeers, err = wgtools.WGShowPeers(iface)
-				if err != nil {
-					return fmt.Errorf("failed listing wireguard peers: %v", err)
-				}
-				// add peers if doesn't exists locally
-				for _, desired := range desiredPeers {
-					// don't process blocked, locked or expired peers
-					if desired.GetStatus() != api.PeerActive || desired.ShouldAutoLock() {
-						continue
-					}
-					logf.Debugf("op=add, peer=%s, status=%v", desired.UID, desired.GetStatus())
-					exists := false
-					for _, curPubKey := range currentPeers {
-						if curPubKey == desired.PublicKeyString() {
-							exists = true
-							break
-						}
-					}
-					if !exists {
-						pubkey := desired.PublicKeyString()
-						logf.Debugf("Adding peer %v/%v", desired.UID, pubkey)
-						stdout, err := wgtools.WGAddPeer(iface, pubkey, desired.Spec.AllowedIPs)
-						if err != nil {
-							msg := fmt.Sprintf("%v. %v", strings.TrimSuffix(string(stdout), "\n"), err)
-							logf.Error(msg)
-							dirty++
-						}
-					}
+				// the peer was deleted meanwhile
+				if peer == nil {
+					continue
 				}
-				logf.WithField("dirty", dirty).Infof("Found %v local and %v remote peers", len(currentPeers), len(desiredPeers))
-				return nil
-			}
-			isControlLoop := sc.PeerDaemon.SyncTime != api.Duration(0)
-			for {
-				now := time.Now().UTC()
-				logf := log.WithFields(map[string]interface{}{
-					"job":    uuid.New().String()[:6],
-					"server": sc.Name,
-				})
-				if err := conciliate(logf); err != nil {
-					if !isControlLoop {
-						return err
-					}
-					logf.Error(err)
+				peer.Status.Stats = s
+				if err := c.Peer().UpdateStatus(peer); err != nil {
+					return err
 				}
-				logf.Infof("Completed in %vs", time.Since(now).Seconds())
-				time.Sleep(time.Duration(sc.PeerDaemon.SyncTime))
 			}
-		},
+			return nil
+		}); err != nil {
+			// the stats are informative, the peers are already reconciled
+			logf.Warnf("failed updating peer stats: %v", err)
+			return nil
+		}
+		logf.Infof("Updated the stats of %v peer(s)", len(stats))
+		return nil
 	}
-	cmd.Flags().StringVarP(&O.ServerConfigPath, "config-file", "c", "", "The wgadmin config file.")
-	return cmd
+	return &daemon{
+		name:          metrics.DaemonPeer,
+		server:        sc.Name,
+		syncTime:      time.Duration(sc.PeerDaemon.SyncTime),
+		listenAddress: sc.PeerDaemon.ListenAddress,
+		staleAfter:    sc.PeerDaemon.GetStaleThreshold(),
+		policy:        sc.PeerDaemon.SyncPolicy,
+		reconcile:     conciliate,
+		close:         wg.Close,
+	}, nil
 }
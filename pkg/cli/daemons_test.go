@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConciliateStateRestartRunsCurrentPostDown(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wgadmin-conciliate-")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "wg0.conf")
+	newConfig := func(postDown string) []byte {
+		return []byte("[Interface]\nAddress = 10.100.0.1/24\nListenPort = 51820\nPrivateKey = key\n" +
+			"PostDown = " + postDown + "\n")
+	}
+	localData := newConfig("iptables -D FORWARD -i %i -j ACCEPT")
+	remoteData := newConfig("iptables -D FORWARD -o %i -j ACCEPT")
+	if err := ioutil.WriteFile(configFile, localData, 0700); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+
+	var calls []string
+	defer func(down, up func(string) ([]byte, error), isRoot func() (bool, error)) {
+		wgQuickDown, wgQuickUp, isRootUser = down, up, isRoot
+	}(wgQuickDown, wgQuickUp, isRootUser)
+	isRootUser = func() (bool, error) { return true, nil }
+	// records the config on disk when each command runs
+	wgQuickDown = func(path string) ([]byte, error) {
+		data, _ := ioutil.ReadFile(path)
+		calls = append(calls, "down:"+string(data))
+		return nil, nil
+	}
+	wgQuickUp = func(path string) ([]byte, error) {
+		data, _ := ioutil.ReadFile(path)
+		calls = append(calls, "up:"+string(data))
+		return nil, nil
+	}
+	logf := log.NewEntry(&log.Logger{Out: ioutil.Discard, Formatter: &log.TextFormatter{}})
+	if _, err := conciliateState(logf, nil, "wg0", configFile, localData, remoteData, false); err != nil {
+		t.Fatalf("failed conciliating state: %v", err)
+	}
+	if len(calls) != 2 || calls[0] != "down:"+string(localData) || calls[1] != "up:"+string(remoteData) {
+		t.Fatalf("expected to bring down the current config and up the new one, got %q", calls)
+	}
+	if _, err := os.Stat(configFile + ".dirty"); !os.IsNotExist(err) {
+		t.Fatalf("expected the dirty file to be removed, got %v", err)
+	}
+	if data, _ := ioutil.ReadFile(configFile); !strings.Contains(string(data), "-o %i") {
+		t.Fatalf("expected the new config on disk, got %q", data)
+	}
+}
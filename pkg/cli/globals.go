@@ -22,10 +22,22 @@ type CmdServer struct {
 	InterfaceName  string
 	Override       bool
 	CipherKey      string
+	OldCipherKey   string
+	NewCipherKey   string
+	Cascade        bool
+	Purge          bool
+	SwitchAt       string
+	SwitchIn       time.Duration
+	GracePeriod    time.Duration
+	Complete       bool
+	Cancel         bool
 }
 
 type CmdPeer struct {
 	Address             string
+	Pool                string
+	Subnets             []string
+	ClientRoutes        []string
 	ExpireAction        string
 	ExpireDuration      string
 	PersistentPublicKey string
@@ -33,6 +45,10 @@ type CmdPeer struct {
 	ClientConfig        bool
 	Override            bool
 	Filename            string
+	Server              string
+	Archived            bool
+	Labels              map[string]string
+	Selector            map[string]string
 }
 
 type CmdConfigure struct {
@@ -54,6 +70,7 @@ type CmdOptions struct {
 	ServerConfigPath   string
 	Output             string
 	Local              bool
+	DryRun             bool
 
 	Server CmdServer
 	Peer   CmdPeer
@@ -69,7 +86,10 @@ var (
 
 	GlobalWGAppConfigPath = os.ExpandEnv("$HOME/.wgapp")
 	GlobalDBFile          = filepath.Join(GlobalWGAppConfigPath, store.DBFileName)
-	GlobalBoltOptions     = &bolt.Options{OpenFile: storeclient.FetchFromGCS}
+	GlobalBoltOptions     = InitEmptyBoltOptions()
+	// GlobalRemoteConfig is the backend used to fetch and sync the database,
+	// the daemons override it with the remote config of the config file.
+	GlobalRemoteConfig = api.NewRemoteConfigFromEnv()
 )
 
 // ParsePersistentPublicKey parse a base64 string pubkey to an api.Key
@@ -102,6 +122,32 @@ func (o *CmdOptions) PrintOutputOptionToStdout(obj interface{}) error {
 	return nil
 }
 
+// newStoreClient opens the database from the remote backend,
+// the local database is used when the --local flag is set
+func newStoreClient() (storeclient.Client, error) {
+	if O.Local {
+		return storeclient.New(GlobalDBFile, nil, GlobalBoltOptions)
+	}
+	remote, err := storeclient.NewRemoteBackend(GlobalRemoteConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed initializing remote backend: %v", err)
+	}
+	return storeclient.New(GlobalDBFile, remote, GlobalBoltOptions)
+}
+
+// updateStore applies fn to the database and syncs the remote backend,
+// fn is replayed against a fresh copy when a concurrent write is detected
+func updateStore(fn storeclient.MutateFn) error {
+	if O.Local {
+		return storeclient.Update(GlobalDBFile, nil, GlobalBoltOptions, fn)
+	}
+	remote, err := storeclient.NewRemoteBackend(GlobalRemoteConfig)
+	if err != nil {
+		return fmt.Errorf("failed initializing remote backend: %v", err)
+	}
+	return storeclient.Update(GlobalDBFile, remote, GlobalBoltOptions, fn)
+}
+
 // InitEmptyBoltOptions initialize an empty bolt.Options
 func InitEmptyBoltOptions() *bolt.Options {
 	return &bolt.Options{}
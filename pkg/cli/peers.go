@@ -15,6 +15,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/ipam"
 	storeclient "github.com/sandromello/wgadmin/pkg/store/client"
 	"github.com/sandromello/wgadmin/pkg/util"
 	"github.com/spf13/cobra"
@@ -36,53 +37,61 @@ func PeerApplyCmd() *cobra.Command {
 				return err
 			}
 			sort.Sort(api.SortPeerByUID(peerList))
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
-			if err != nil {
-				return err
-			}
-			success := 0
-			for _, new := range peerList {
-				peerServer := new.GetServer()
-				// TODO: There's no need to fetch a server for every single peer config, improve later
-				wgsc, err := client.WireguardServerConfig().Get(peerServer)
-				if err != nil || wgsc == nil {
-					return fmt.Errorf("failed fetching server %v, err=%v", peerServer, err)
-				}
-				old, err := client.Peer().Get(new.UID)
-				if err != nil {
-					return fmt.Errorf("failed fetching peer %s, err=%v", new.UID, err)
-				}
-				ipmap, err := buildIPMap(client, wgsc)
-				if err != nil {
-					return err
-				}
-				if old == nil {
-					if err := validatePeer(&new); err != nil {
-						return fmt.Errorf("failed validating peer %s, err=%v", new.UID, err)
+			var success int
+			if err := updateStore(func(client storeclient.Client) error {
+				success = 0
+				for _, new := range peerList {
+					peerServer := new.GetServer()
+					// TODO: There's no need to fetch a server for every single peer config, improve later
+					wgsc, err := client.WireguardServerConfig().Get(peerServer)
+					if err != nil || wgsc == nil {
+						return fmt.Errorf("failed fetching server %v, err=%v", peerServer, err)
 					}
-					ipaddr := new.ParseAllowedIPs()
-					if !ipmap.IsAvailable(ipaddr) {
-						return fmt.Errorf("peer %s has ip %q which isn't available for network %v", new.UID, ipaddr.String(), ipmap.Net.String())
+					old, err := client.Peer().Get(new.UID)
+					if err != nil {
+						return fmt.Errorf("failed fetching peer %s, err=%v", new.UID, err)
 					}
-					new.CreatedAt = time.Now().UTC().Format(time.RFC3339)
-					if err := client.Peer().Update(&new); err != nil {
-						return fmt.Errorf("failed creating new peer %s, err=%v", new.UID, err)
+					ipmap, peers, err := buildIPMap(client, wgsc)
+					if err != nil {
+						return err
 					}
-					success++
-				} else if !reflect.DeepEqual(old.Spec, new.Spec) {
-					ipaddr := new.ParseAllowedIPs()
-					if old.Spec.AllowedIPs != new.Spec.AllowedIPs && !ipmap.IsAvailable(ipaddr) {
-						return fmt.Errorf("peer %s has ip %q which isn't available for network %v", new.UID, ipaddr.String(), ipmap.Net.String())
+					if err := validateSubnets(ipmap, peers, &new); err != nil {
+						return err
+					}
+					if err := validateRoutes(new.Spec.ClientRoutes); err != nil {
+						return fmt.Errorf("failed validating peer %s, err=%v", new.UID, err)
 					}
-					new.Metadata = old.Metadata
-					new.Status = old.Status
-					if err := client.Peer().Update(&new); err != nil {
-						return fmt.Errorf("failed updating peer %s, err=%v", new.UID, err)
+					if old == nil {
+						if err := validatePeer(&new); err != nil {
+							return fmt.Errorf("failed validating peer %s, err=%v", new.UID, err)
+						}
+						if err := allocatePeerIPs(ipmap, &new); err != nil {
+							return err
+						}
+						new.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+						if err := client.Peer().Update(&new); err != nil {
+							return fmt.Errorf("failed creating new peer %s, err=%v", new.UID, err)
+						}
+						success++
+					} else if !reflect.DeepEqual(old.Spec, new.Spec) {
+						if old.Spec.AllowedIPs != new.Spec.AllowedIPs {
+							for _, ipaddr := range old.ParseAllowedIPs() {
+								ipmap.Release(ipaddr)
+							}
+							if err := allocatePeerIPs(ipmap, &new); err != nil {
+								return err
+							}
+						}
+						new.Metadata = old.Metadata
+						new.Status = old.Status
+						if err := client.Peer().Update(&new); err != nil {
+							return fmt.Errorf("failed updating peer %s, err=%v", new.UID, err)
+						}
+						success++
 					}
-					success++
 				}
-			}
-			if err := client.SyncRemote(); err != nil {
+				return nil
+			}); err != nil {
 				return err
 			}
 			if success == 0 {
@@ -112,21 +121,120 @@ func validatePeer(p *api.Peer) error {
 	return err
 }
 
-func buildIPMap(client storeclient.Client, wgsc *api.WireguardServerConfig) (*util.IPMap, error) {
+// buildIPMap returns the ip map of a server with the addresses of its peers allocated
+func buildIPMap(client storeclient.Client, wgsc *api.WireguardServerConfig) (*ipam.Map, []api.Peer, error) {
 	peerList, err := client.Peer().ListByServer(wgsc.UID)
 	if err != nil {
-		return nil, fmt.Errorf("failed listing peers. err=%v", err)
+		return nil, nil, fmt.Errorf("failed listing peers. err=%v", err)
 	}
-	ipmap, err := util.NewIPMap(wgsc.Address)
+	ipmap, err := newIPMap(wgsc)
 	if err != nil {
-		return nil, fmt.Errorf("failed creating ip map. err=%v", err)
+		return nil, nil, fmt.Errorf("failed creating ip map. err=%v", err)
 	}
 	for _, p := range peerList {
-		ipmap.Del(p.ParseAllowedIPs().String())
+		for _, ipaddr := range p.ParseAllowedIPs() {
+			// peers outside of the server network don't take any address
+			_ = ipmap.Allocate(ipaddr)
+		}
+	}
+	return ipmap, peerList, nil
+}
+
+// newIPMap creates an ip map of the server networks excluding its reserved ranges and pools
+func newIPMap(wgsc *api.WireguardServerConfig) (*ipam.Map, error) {
+	ipmap, err := ipam.New(wgsc.GetAddresses()...)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range wgsc.Reserved {
+		ipnet, err := ipam.ParseNetwork(address)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing reserved address: %v", err)
+		}
+		if err := ipmap.Reserve(ipnet); err != nil {
+			return nil, err
+		}
+	}
+	pools := map[string]bool{}
+	for _, pool := range wgsc.Pools {
+		if pool.Name == "" || pools[pool.Name] {
+			return nil, fmt.Errorf("pool name %q is empty or duplicated", pool.Name)
+		}
+		pools[pool.Name] = true
+		for _, address := range api.SplitAddresses(pool.Address) {
+			ipnet, err := ipam.ParseNetwork(address)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing address of pool %q: %v", pool.Name, err)
+			}
+			if err := ipmap.AddPool(pool.Name, ipnet); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return ipmap, nil
 }
 
+// validateRoutes verifies if the routes are networks in CIDR notation
+func validateRoutes(routes []string) error {
+	for _, route := range routes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return fmt.Errorf("failed parsing route: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateSubnets verifies if the subnets of a peer are networks which don't overlap
+// with the server networks, with each other or with the allowed ips of the other peers
+func validateSubnets(ipmap *ipam.Map, peers []api.Peer, p *api.Peer) error {
+	var subnets []*net.IPNet
+	for _, subnet := range p.Spec.Subnets {
+		ip, ipnet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return fmt.Errorf("failed parsing subnet of peer %s: %v", p.UID, err)
+		}
+		if !ip.Equal(ipnet.IP) {
+			return fmt.Errorf("subnet %q of peer %s isn't a network address, e.g.: %v", subnet, p.UID, ipnet)
+		}
+		for _, a := range ipmap.Allocators {
+			if ipam.Overlaps(a.Net, ipnet) {
+				return fmt.Errorf("subnet %v of peer %s overlaps with network %v", ipnet, p.UID, a.Net)
+			}
+		}
+		for _, prev := range subnets {
+			if ipam.Overlaps(prev, ipnet) {
+				return fmt.Errorf("subnet %v of peer %s overlaps with its subnet %v", ipnet, p.UID, prev)
+			}
+		}
+		subnets = append(subnets, ipnet)
+		for _, other := range peers {
+			if other.UID == p.UID {
+				continue
+			}
+			for _, allowedIP := range other.GetAllowedIPs() {
+				if otherNet, err := ipam.ParseNetwork(allowedIP); err == nil && ipam.Overlaps(ipnet, otherNet) {
+					return fmt.Errorf("subnet %v of peer %s overlaps with allowed ip %v of peer %s", ipnet, p.UID, otherNet, other.UID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// allocatePeerIPs allocates the addresses of a peer, it fails if any of them isn't available
+func allocatePeerIPs(ipmap *ipam.Map, p *api.Peer) error {
+	ipaddrs := p.ParseAllowedIPs()
+	if len(ipaddrs) == 0 {
+		return fmt.Errorf("peer %s doesn't have an address, allowedIPs=%q", p.UID, p.Spec.AllowedIPs)
+	}
+	for _, ipaddr := range ipaddrs {
+		if err := ipmap.Allocate(ipaddr); err != nil {
+			return fmt.Errorf("peer %s has ip %q which isn't available for network %v", p.UID, ipaddr.String(), ipmap.String())
+		}
+	}
+	return nil
+}
+
 // PeerAddCmd add a new peer
 func PeerAddCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -143,104 +251,212 @@ func PeerAddCmd() *cobra.Command {
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
-			if err != nil {
-				return err
-			}
 			// <server>/<peer>
 			parts := strings.Split(args[0], "/")
-			p, err := client.Peer().Get(args[0])
-			if err == nil && (p == nil || O.Peer.Override) {
-				persistentPubKey, err := O.ParsePersistentPublicKey()
+			persistentPubKey, err := O.ParsePersistentPublicKey()
+			if err != nil {
+				return fmt.Errorf("failed parsing persistent public key: %v", err)
+			}
+			var wireguardClientConfig []byte
+			if err := updateStore(func(client storeclient.Client) error {
+				p, err := client.Peer().Get(args[0])
 				if err != nil {
-					return fmt.Errorf("failed parsing persistent public key: %v", err)
+					// failed veryfing if peer exists
+					return fmt.Errorf("failed fetching peer: %v", err)
 				}
-				var allowedIPs *net.IPNet
-				if O.Peer.Address != "" {
-					allowedIPs = api.ParseCIDR(O.Peer.Address)
-					if allowedIPs == nil {
-						return fmt.Errorf("failed parsing ip address: %v", O.Peer.Address)
+				if p != nil && !O.Peer.Override {
+					return fmt.Errorf("peer already exists: %v", p.UID)
+				}
+				var allowedIPs []*net.IPNet
+				for _, address := range api.SplitAddresses(O.Peer.Address) {
+					ipnet := api.ParseCIDR(address)
+					if ipnet == nil {
+						return fmt.Errorf("failed parsing ip address: %v", address)
 					}
+					allowedIPs = append(allowedIPs, ipnet)
 				}
 
 				wgsc, err := client.WireguardServerConfig().Get(parts[0])
 				if err != nil || wgsc == nil {
 					return fmt.Errorf("failed fetching server %v, err=%v", parts[0], err)
 				}
-				ipmap, err := buildIPMap(client, wgsc)
+				ipmap, peers, err := buildIPMap(client, wgsc)
 				if err != nil {
 					return err
 				}
-				if allowedIPs == nil {
-					allowedIPs = ipmap.Pop()
-					if allowedIPs == nil {
-						return fmt.Errorf("reach maximum allocation for network %v", ipmap.Net.String())
+				if p != nil {
+					// the overridden peer gives its addresses back
+					for _, ipaddr := range p.ParseAllowedIPs() {
+						ipmap.Release(ipaddr)
 					}
-				} else {
-					if !ipmap.Net.Contains(allowedIPs.IP) {
-						return fmt.Errorf("ip=%s doesn't belong to network=%v", allowedIPs.IP.String(), ipmap.Net.String())
+				}
+				if err := validateSubnets(ipmap, peers, &api.Peer{
+					Metadata: api.Metadata{UID: args[0]},
+					Spec:     api.PeerSpec{Subnets: O.Peer.Subnets},
+				}); err != nil {
+					return err
+				}
+				var explicitIPs []net.IP
+				for _, ipnet := range allowedIPs {
+					if !ipmap.Contains(ipnet.IP) {
+						return fmt.Errorf("ip=%s doesn't belong to network=%v", ipnet.IP.String(), ipmap.String())
 					}
-					if !ipmap.IsAvailable(allowedIPs.IP) {
-						return fmt.Errorf("the ip=%v isn't available", allowedIPs.IP.String())
+					if err := ipmap.Allocate(ipnet.IP); err != nil {
+						return fmt.Errorf("the ip=%v isn't available", ipnet.IP.String())
 					}
+					explicitIPs = append(explicitIPs, ipnet.IP)
+				}
+				now := time.Now().UTC()
+				// the families without an explicit address are allocated automatically
+				var ipnets []*net.IPNet
+				if O.Peer.Pool != "" {
+					ipnets, err = ipmap.PopPool(O.Peer.Pool, explicitIPs...)
+				} else {
+					ipnets, err = ipmap.Pop(explicitIPs...)
+				}
+				if err != nil {
+					return err
+				}
+				allowedIPs = append(allowedIPs, ipnets...)
+				sort.SliceStable(allowedIPs, func(i, j int) bool {
+					return allowedIPs[i].IP.To4() != nil && allowedIPs[j].IP.To4() == nil
+				})
+				var addresses []string
+				for _, ipnet := range allowedIPs {
+					addresses = append(addresses, ipnet.String())
+				}
+				peer := &api.Peer{
+					Metadata: api.Metadata{
+						UID:       args[0],
+						Labels:    O.Peer.Labels,
+						CreatedAt: now.Format(time.RFC3339),
+					},
+					Spec: api.PeerSpec{
+						PersistentPublicKey: persistentPubKey,
+						// TODO: validate expire action first
+						ExpireAction: api.PeerExpireActionType(O.Peer.ExpireAction),
+						// TODO: parse expire duration
+						ExpireDuration: "24h",
+						ClientMTU:      O.Peer.MTU,
+						AllowedIPs:     strings.Join(addresses, ", "),
+						Subnets:        O.Peer.Subnets,
+						ClientRoutes:   O.Peer.ClientRoutes,
+					},
+				}
+				if err := validateRoutes(peer.Spec.ClientRoutes); err != nil {
+					return err
 				}
-				var wireguardClientConfig []byte
 				if O.Peer.ClientConfig {
 					clientPrivkey, err := api.GeneratePrivateKey()
 					if err != nil {
 						return fmt.Errorf("failed generating private key for client config, err=%v", err)
 					}
 					pubkey := clientPrivkey.PublicKey()
-					persistentPubKey = &pubkey
+					peer.Spec.PersistentPublicKey = &pubkey
 					wireguardClientConfig, err = api.ParseWireguardClientConfigTemplate(map[string]interface{}{
 						"PrivateKey": clientPrivkey,
-						"PublicKey":  wgsc.PublicKey.String(),
-						"Address":    allowedIPs.String(),
+						"PublicKey":  wgsc.GetActivePublicKey(now).String(),
+						"Address":    peer.Spec.AllowedIPs,
 						"DNS":        "1.1.1.1, 8.8.8.8",
 						"MTU":        O.Peer.MTU,
 						"Endpoint":   wgsc.PublicEndpoint,
-						"AllowedIPs": "0.0.0.0/0, ::/0",
+						"AllowedIPs": peer.GetClientRoutes(wgsc),
 					})
 					if err != nil {
 						return fmt.Errorf("failed generating client config, err=%v", err)
 					}
+					// a client config issued before the switch has the previous key of the server
+					peer.Status.RenewConfig = wgsc.KeyRotation != nil && !wgsc.KeyRotation.IsSwitched(now)
 				}
-				if err := client.Peer().Update(&api.Peer{
-					Metadata: api.Metadata{
-						UID:       args[0],
-						CreatedAt: time.Now().UTC().Format(time.RFC3339),
-					},
-					Spec: api.PeerSpec{
-						PersistentPublicKey: persistentPubKey,
-						// TODO: validate expire action first
-						ExpireAction: api.PeerExpireActionType(O.Peer.ExpireAction),
-						// TODO: parse expire duration
-						ExpireDuration: "24h",
-						ClientMTU:      O.Peer.MTU,
-						AllowedIPs:     allowedIPs.String(),
-					},
-				}); err != nil {
-					return err
-				}
-				if wireguardClientConfig != nil {
-					fmt.Print(string(wireguardClientConfig))
-				}
-			} else if err != nil {
-				// failed veryfing if peer exists
-				return fmt.Errorf("failed fetching peer: %v", err)
-			} else if p != nil {
-				return fmt.Errorf("peer already exists: %v", p.UID)
+				return client.Peer().Update(peer)
+			}); err != nil {
+				return err
+			}
+			if wireguardClientConfig != nil {
+				fmt.Print(string(wireguardClientConfig))
 			}
-			return client.SyncRemote()
+			return nil
 		},
 	}
-	cmd.Flags().StringVar(&O.Peer.Address, "address", "", "The address of the peer, must not overlap with other peers.")
+	cmd.Flags().StringArrayVar(&O.Peer.ClientRoutes, "client-route", nil, "A network routed through the tunnel by the client, overrides the client routes of the server. Could be specified multiple times.")
+	cmd.Flags().StringArrayVar(&O.Peer.Subnets, "subnet", nil, "A network routed through the peer (site-to-site), could be specified multiple times.")
+	cmd.Flags().StringVar(&O.Peer.Pool, "pool", "", "Allocate the addresses from a pool of the server.")
+	cmd.Flags().StringVar(&O.Peer.Address, "address", "", "The comma separated addresses of the peer, must not overlap with other peers. The families without an address are allocated automatically.")
 	cmd.Flags().StringVar(&O.Peer.ExpireAction, "expire-action", string(api.PeerExpireActionDefault), "The action to perform when expiring peers: block|reset.")
 	cmd.Flags().StringVar(&O.Peer.ExpireDuration, "expire-in", "24h", "The duration for auto expiring or locking the peer.")
 	cmd.Flags().StringVar(&O.Peer.PersistentPublicKey, "public-key", "", "The public key to add to the peer, this key will never expire.")
 	cmd.Flags().StringVar(&O.Peer.MTU, "mtu", api.PeerDefaultMTU, "The MTU of the client config.")
 	cmd.Flags().BoolVar(&O.Peer.ClientConfig, "client-config", false, "Generate a wireguard client config, this public key will never expire.")
 	cmd.Flags().BoolVar(&O.Peer.Override, "override", false, "Override the configured peer, it will reset the current configuration.")
+	cmd.Flags().StringToStringVarP(&O.Peer.Labels, "label", "l", nil, "Labels to add to the peer, e.g.: team=infra.")
+	return cmd
+}
+
+// PeerDeleteCmd removes peers and release their addresses, a record of the
+// deleted peers is kept in the archive.
+func PeerDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "delete [SERVER/PEER...]",
+		Short:        "Delete peers from a wireguard server config.",
+		SilenceUsage: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			isBulk := O.Peer.Server != "" || len(O.Peer.Selector) > 0
+			if len(args) == 0 && !isBulk {
+				return errors.New("missing the resource name, --server or --selector")
+			}
+			if len(args) > 0 && isBulk {
+				return errors.New("the resource name can't be used with --server or --selector")
+			}
+			for _, uid := range args {
+				if !strings.Contains(uid, "/") {
+					return errors.New("specify the resource name as <SERVER>/<NAME>")
+				}
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deletedBy := currentUser()
+			var deleted []api.Peer
+			if err := updateStore(func(client storeclient.Client) error {
+				deleted = nil
+				uids := args
+				if len(uids) == 0 {
+					peerList, err := client.Peer().ListByServer(O.Peer.Server)
+					if err != nil {
+						return fmt.Errorf("failed listing peers: %v", err)
+					}
+					for _, p := range peerList {
+						if p.MatchLabels(O.Peer.Selector) {
+							uids = append(uids, p.UID)
+						}
+					}
+				}
+				for _, uid := range uids {
+					p, err := client.Peer().Archive(uid, deletedBy)
+					if err != nil {
+						return fmt.Errorf("failed deleting peer %s, err=%v", uid, err)
+					}
+					if p == nil {
+						return fmt.Errorf("peer %q not found", uid)
+					}
+					deleted = append(deleted, *p)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if len(deleted) == 0 {
+				fmt.Println("No resources found.")
+				return nil
+			}
+			for _, p := range deleted {
+				fmt.Printf("peer %q deleted, address %s released!\n", p.UID, p.Spec.AllowedIPs)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&O.Peer.Server, "server", "", "Delete all peers from the given server.")
+	cmd.Flags().StringToStringVarP(&O.Peer.Selector, "selector", "l", nil, "Delete only the peers matching the labels, e.g.: team=infra.")
 	return cmd
 }
 
@@ -256,7 +472,7 @@ func PeerInfoCmd() *cobra.Command {
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
+			client, err := newStoreClient()
 			if err != nil {
 				return err
 			}
@@ -292,8 +508,25 @@ func PeerInfoCmd() *cobra.Command {
 			fmt.Println("EXPIREACTION:", expireAction)
 			fmt.Println("EXPIREDURATION:", expireDuration)
 			fmt.Println("ALLOWEDIPS:", peer.Spec.AllowedIPs)
+			fmt.Println("SUBNETS:", strings.Join(peer.Spec.Subnets, ", "))
+			fmt.Println("CLIENTROUTES:", strings.Join(peer.Spec.ClientRoutes, ", "))
 			fmt.Println("AUTOLOCK:", peer.ShouldAutoLock())
 			fmt.Println("STATUS:", peer.GetStatus())
+			fmt.Println("RENEWCONFIG:", peer.Status.RenewConfig)
+			if stats := peer.Status.Stats; stats != nil {
+				lastHandshake := "-"
+				if stats.LastHandshake != "" {
+					lastHandshake = stats.LastHandshake
+				}
+				endpoint := "-"
+				if stats.Endpoint != "" {
+					endpoint = stats.Endpoint
+				}
+				fmt.Println("LASTHANDSHAKE:", lastHandshake)
+				fmt.Println("ENDPOINT:", endpoint)
+				fmt.Println("TRANSFER:", util.HumanizeBytes(stats.TransferRx), "received,", util.HumanizeBytes(stats.TransferTx), "sent")
+				fmt.Println("STATSUPDATEDAT:", stats.UpdatedAt)
+			}
 			return nil
 		},
 	}
@@ -317,23 +550,21 @@ func PeerBlockCmd() *cobra.Command {
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
-			if err != nil {
-				return err
-			}
-			peer, err := client.Peer().Get(args[0])
-			if err != nil {
-				return err
-			}
-			if peer == nil {
-				return fmt.Errorf("peer not found")
-			}
-			peer.Spec.Blocked = true
-			if err := client.Peer().Update(peer); err != nil {
+			if err := updateStore(func(client storeclient.Client) error {
+				peer, err := client.Peer().Get(args[0])
+				if err != nil {
+					return err
+				}
+				if peer == nil {
+					return fmt.Errorf("peer not found")
+				}
+				peer.Spec.Blocked = true
+				return client.Peer().Update(peer)
+			}); err != nil {
 				return err
 			}
-			fmt.Printf("peer %q is blocked!\n", peer.UID)
-			return client.SyncRemote()
+			fmt.Printf("peer %q is blocked!\n", args[0])
+			return nil
 		},
 	}
 }
@@ -354,23 +585,21 @@ func PeerUnblockCmd() *cobra.Command {
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
-			if err != nil {
-				return err
-			}
-			peer, err := client.Peer().Get(args[0])
-			if err != nil {
-				return err
-			}
-			if peer == nil {
-				return fmt.Errorf("peer not found")
-			}
-			peer.Spec.Blocked = false
-			if err := client.Peer().Update(peer); err != nil {
+			if err := updateStore(func(client storeclient.Client) error {
+				peer, err := client.Peer().Get(args[0])
+				if err != nil {
+					return err
+				}
+				if peer == nil {
+					return fmt.Errorf("peer not found")
+				}
+				peer.Spec.Blocked = false
+				return client.Peer().Update(peer)
+			}); err != nil {
 				return err
 			}
-			fmt.Printf("peer %q is active!\n", peer.UID)
-			return client.SyncRemote()
+			fmt.Printf("peer %q is active!\n", args[0])
+			return nil
 		},
 	}
 }
@@ -381,7 +610,7 @@ func PeerListCmd() *cobra.Command {
 		Short:        "List peers from a given server.",
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
+			client, err := newStoreClient()
 			if err != nil {
 				return err
 			}
@@ -389,10 +618,20 @@ func PeerListCmd() *cobra.Command {
 			if len(args) > 0 {
 				serverPrefix = args[0]
 			}
-			peerList, err := client.Peer().ListByServer(serverPrefix)
+			listFn := client.Peer().ListByServer
+			if O.Peer.Archived {
+				listFn = client.Peer().ListArchived
+			}
+			var peerList []api.Peer
+			allPeers, err := listFn(serverPrefix)
 			if err != nil {
 				return err
 			}
+			for _, p := range allPeers {
+				if p.MatchLabels(O.Peer.Selector) {
+					peerList = append(peerList, p)
+				}
+			}
 			if O.Output != "" {
 				return O.PrintOutputOptionToStdout(peerList)
 			}
@@ -403,8 +642,17 @@ func PeerListCmd() *cobra.Command {
 				fmt.Println("No resources found.")
 				return nil
 			}
+			if O.Peer.Archived {
+				fmt.Fprintln(w, "UID\tALLOWEDIP\tDELETED BY\tDELETED AT\t")
+				for _, p := range peerList {
+					deletedAt := util.GetDeltaDuration(p.DeletedAt, "")
+					fmt.Fprintf(w, "%s\t%s\t%s\t%v\t", p.UID, p.Spec.AllowedIPs, p.DeletedBy, deletedAt)
+					fmt.Fprintln(w)
+				}
+				return nil
+			}
 
-			fmt.Fprintln(w, "UID\tALLOWEDIP\tSECRET\tPUBKEY\tSTATUS\tEXPIRE IN\tUPDATED AT\t")
+			fmt.Fprintln(w, "UID\tALLOWEDIP\tSECRET\tPUBKEY\tSTATUS\tEXPIRE IN\tHANDSHAKE\tUPDATED AT\t")
 			for _, p := range peerList {
 				var pubkey string
 				if p.Spec.PersistentPublicKey != nil {
@@ -433,8 +681,12 @@ func PeerListCmd() *cobra.Command {
 				default:
 					expin = d.String()
 				}
+				handshake := "-"
+				if p.Status.Stats != nil && p.Status.Stats.LastHandshake != "" {
+					handshake = util.GetDeltaDuration(p.Status.Stats.LastHandshake, "")
+				}
 				updatedAt := util.GetDeltaDuration(p.UpdatedAt, "")
-				fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%v\t%v\t%v\t", p.UID, ipaddr, secret, pubkey, p.GetStatus(), expin, updatedAt)
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%v\t%v\t%v\t%v\t", p.UID, ipaddr, secret, pubkey, p.GetStatus(), expin, handshake, updatedAt)
 				fmt.Fprintln(w)
 			}
 
@@ -442,5 +694,7 @@ func PeerListCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVarP(&O.Output, "output", "o", "", "Output format. One of: json|yaml.")
+	cmd.Flags().StringToStringVarP(&O.Peer.Selector, "selector", "l", nil, "Filter peers by labels, e.g.: team=infra.")
+	cmd.Flags().BoolVar(&O.Peer.Archived, "archived", false, "List the deleted peers.")
 	return cmd
 }
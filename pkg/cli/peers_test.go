@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/ipam"
+)
+
+func TestValidateSubnets(t *testing.T) {
+	ipmap, err := ipam.New("10.100.0.1/24", "fd00:100::1/64")
+	if err != nil {
+		t.Fatalf("failed creating ip map: %v", err)
+	}
+	newPeer := func(uid, allowedIPs string, subnets ...string) api.Peer {
+		return api.Peer{
+			Metadata: api.Metadata{UID: uid},
+			Spec:     api.PeerSpec{AllowedIPs: allowedIPs, Subnets: subnets},
+		}
+	}
+	peers := []api.Peer{
+		newPeer("office", "10.100.0.2/32", "192.168.10.0/24"),
+		newPeer("lab", "10.100.0.3/32, 172.16.0.0/16"),
+	}
+	for _, tt := range []struct {
+		name    string
+		subnets []string
+		wantErr bool
+	}{
+		{name: "disjoint subnets", subnets: []string{"192.168.20.0/24", "fd10::/64"}},
+		{name: "not a network address", subnets: []string{"192.168.20.1/24"}, wantErr: true},
+		{name: "overlaps with the server network", subnets: []string{"10.100.0.0/16"}, wantErr: true},
+		{name: "overlaps with a subnet of other peer", subnets: []string{"192.168.10.128/25"}, wantErr: true},
+		{name: "overlaps with an allowed ip of other peer", subnets: []string{"172.16.1.0/24"}, wantErr: true},
+		{name: "overlaps with its own subnet", subnets: []string{"192.168.32.0/20", "192.168.40.0/24"}, wantErr: true},
+		{name: "duplicated subnet", subnets: []string{"192.168.20.0/24", "192.168.20.0/24"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPeer("site", "10.100.0.4/32", tt.subnets...)
+			err := validateSubnets(ipmap, peers, &p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error, wantErr=%v, got=%v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/util"
+	"github.com/sandromello/wgadmin/pkg/wgtools"
+	log "github.com/sirupsen/logrus"
+)
+
+// serverPlan is what sync-servers would apply to the interface
+type serverPlan struct {
+	Server     string                   `json:"server"`
+	ConfigFile string                   `json:"configFile"`
+	Interface  string                   `json:"interface"`
+	Dirty      bool                     `json:"dirty"`
+	Diff       []string                 `json:"diff,omitempty"`
+	Changes    wgtools.InterfaceChanges `json:"changes"`
+	// Action is one of: none, write-config, configure or restart
+	Action string `json:"action"`
+}
+
+// peerChange is a peer which sync-peers would add, update or remove
+type peerChange struct {
+	Action     string   `json:"action"`
+	UID        string   `json:"uid,omitempty"`
+	PublicKey  string   `json:"publicKey"`
+	AllowedIPs []string `json:"allowedIPs,omitempty"`
+}
+
+// peerPlan is what sync-peers would apply to the interface
+type peerPlan struct {
+	Server       string       `json:"server"`
+	Interface    string       `json:"interface"`
+	LocalPeers   int          `json:"localPeers"`
+	DesiredPeers int          `json:"desiredPeers"`
+	Changes      []peerChange `json:"changes"`
+}
+
+// redactPrivateKey splits a wireguard config in lines replacing its private key
+func redactPrivateKey(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "PrivateKey") {
+			redacted := "REDACTED"
+			if k, err := api.ParseKey(strings.TrimSpace(parts[1])); err == nil {
+				redacted = fmt.Sprintf("REDACTED (public key %s)", k.PublicKey())
+			}
+			line = parts[0] + "= " + redacted
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// printServerPlan prints the config diff and the action which sync-servers would take
+func printServerPlan() error {
+	sc, err := parseServerConfigFile(O.ServerConfigPath)
+	if err != nil {
+		return err
+	}
+	GlobalRemoteConfig = sc.GetRemoteConfig()
+	if err := setServerCipherKey(sc); err != nil {
+		return err
+	}
+	localData, remoteData, _, err := fetchState(sc)
+	if err != nil {
+		return err
+	}
+	configFile := sc.GetWireguardConfigFile()
+	isDirty, err := checkDirty(configFile)
+	if err != nil {
+		return err
+	}
+	plan := &serverPlan{
+		Server:     sc.Name,
+		ConfigFile: configFile,
+		Interface:  sc.GetInterfaceName(),
+		Dirty:      isDirty,
+		Action:     "none",
+	}
+	if hashFromByte(localData) != hashFromByte(remoteData) || isDirty {
+		plan.Diff = util.DiffLines(redactPrivateKey(localData), redactPrivateKey(remoteData))
+		if plan.Changes, _, err = planInterfaceChanges(localData, remoteData, isDirty); err != nil {
+			return err
+		}
+		switch {
+		case plan.Changes.Restart:
+			plan.Action = "restart"
+		case plan.Changes.IsLive():
+			plan.Action = "configure"
+		default:
+			plan.Action = "write-config"
+		}
+	}
+	if O.Output != "" {
+		return O.PrintOutputOptionToStdout(plan)
+	}
+	fmt.Printf("SERVER: %s\n", plan.Server)
+	fmt.Printf("CONFIGFILE: %s\n", plan.ConfigFile)
+	fmt.Printf("DIRTY: %v\n", plan.Dirty)
+	fmt.Printf("ACTION: %s\n", plan.Action)
+	if len(plan.Diff) > 0 {
+		fmt.Printf("--- %s\n+++ %s\n", plan.ConfigFile, plan.Server)
+		fmt.Println(strings.Join(plan.Diff, "\n"))
+	}
+	return nil
+}
+
+// printPeerPlan prints the peers which sync-peers would add, update or remove
+func printPeerPlan() error {
+	sc, err := parseServerConfigFile(O.ServerConfigPath)
+	if err != nil {
+		return err
+	}
+	GlobalRemoteConfig = sc.GetRemoteConfig()
+	client, err := newStoreClient()
+	if err != nil {
+		return err
+	}
+	desiredPeers, err := client.Peer().ListByServer(sc.Name)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed listing peers: %v", err)
+	}
+	archivedPeers, err := client.Peer().ListArchived(sc.Name)
+	client.Close()
+	if err != nil {
+		return fmt.Errorf("failed listing deleted peers: %v", err)
+	}
+	wg, err := wgtools.NewClient(wgtools.Backend(sc.PeerDaemon.Backend))
+	if err != nil {
+		return err
+	}
+	defer wg.Close()
+	iface := sc.PeerDaemon.InterfaceName
+	// the reconciler logs the changes as if they were applied
+	logf := log.NewEntry(&log.Logger{Out: ioutil.Discard, Formatter: &log.TextFormatter{}})
+	result, err := wgtools.NewPeerReconciler(wgtools.NewDevice(wg, iface)).Plan(logf, desiredPeers, archivedPeers)
+	if err != nil {
+		return err
+	}
+
+	uids := map[string]string{}
+	for _, peers := range [][]api.Peer{archivedPeers, desiredPeers} {
+		for _, p := range peers {
+			uids[p.PublicKeyString()] = p.UID
+		}
+	}
+	localPeers := map[string]bool{}
+	for _, p := range result.Peers {
+		localPeers[p.PublicKey] = true
+	}
+	plan := &peerPlan{
+		Server:       sc.Name,
+		Interface:    iface,
+		LocalPeers:   len(result.Peers),
+		DesiredPeers: len(desiredPeers),
+		Changes:      []peerChange{},
+	}
+	for _, c := range result.Changes {
+		action := "add"
+		switch {
+		case c.Remove:
+			action = "remove"
+		case localPeers[c.PublicKey]:
+			action = "update"
+		}
+		plan.Changes = append(plan.Changes, peerChange{
+			Action:     action,
+			UID:        uids[c.PublicKey],
+			PublicKey:  c.PublicKey,
+			AllowedIPs: c.AllowedIPs,
+		})
+	}
+	if O.Output != "" {
+		return O.PrintOutputOptionToStdout(plan)
+	}
+	fmt.Printf("SERVER: %s\n", plan.Server)
+	fmt.Printf("INTERFACE: %s\n", plan.Interface)
+	fmt.Printf("PEERS: %d local, %d desired\n", plan.LocalPeers, plan.DesiredPeers)
+	if len(plan.Changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, '\t', tabwriter.AlignRight)
+	defer w.Flush()
+	fmt.Fprintln(w, "ACTION\tUID\tPUBKEY\tALLOWEDIPS\t")
+	for _, c := range plan.Changes {
+		uid := c.UID
+		if uid == "" {
+			uid = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t", c.Action, uid, c.PublicKey, strings.Join(c.AllowedIPs, ","))
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
@@ -8,9 +9,11 @@ import (
 	"text/tabwriter"
 	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/sandromello/wgadmin/pkg/util"
 
 	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/ipam"
 	storeclient "github.com/sandromello/wgadmin/pkg/store/client"
 	"github.com/spf13/cobra"
 )
@@ -22,7 +25,7 @@ func ListServer() *cobra.Command {
 		Short:        "List wireguard servers configs.",
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
+			client, err := newStoreClient()
 			if err != nil {
 				return err
 			}
@@ -40,10 +43,21 @@ func ListServer() *cobra.Command {
 				fmt.Println("No resources found.")
 				return nil
 			}
-			fmt.Fprintln(w, "UID\tADDRESS\tPORT\tPUBKEY\t")
+			fmt.Fprintln(w, "UID\tADDRESS\tPORT\tPUBKEY\tKEY ROTATION\t")
+			now := time.Now().UTC()
 			for _, wg := range wgscList {
-				pubkey := wg.PublicKey.String()
-				fmt.Fprintf(w, "%s\t%s\t%v\t%s\t", wg.UID, wg.Address, wg.ListenPort, pubkey)
+				pubkey := wg.GetActivePublicKey(now).String()
+				rotation := "-"
+				switch r := wg.KeyRotation; {
+				case r == nil:
+				case !r.IsSwitched(now):
+					rotation = fmt.Sprintf("switch in %v", util.RoundTime(r.GetSwitchTime().Sub(now), time.Second))
+				case !r.IsComplete(now):
+					rotation = "grace period"
+				default:
+					rotation = "completed"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t", wg.UID, wg.Address, wg.ListenPort, pubkey, rotation)
 				fmt.Fprintln(w)
 			}
 			return nil
@@ -66,20 +80,41 @@ func DeleteServer() *cobra.Command {
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
-			if err != nil {
+			deletedBy := currentUser()
+			var removedPeers []api.Peer
+			if err := updateStore(func(client storeclient.Client) error {
+				wgsc, err := client.WireguardServerConfig().Get(args[0])
+				if err != nil {
+					return err
+				}
+				if wgsc == nil {
+					return fmt.Errorf("wireguard server %q not found", args[0])
+				}
+				peerList, err := client.Peer().ListByServer(args[0])
+				if err != nil {
+					return fmt.Errorf("failed listing peers: %v", err)
+				}
+				if len(peerList) > 0 && !O.Server.Cascade {
+					return fmt.Errorf("wireguard server %q has %d peer(s), use --cascade to remove them", args[0], len(peerList))
+				}
+				removedPeers, err = client.WireguardServerConfig().DeleteCascade(args[0], deletedBy, O.Server.Purge)
 				return err
-			}
-			if err := client.WireguardServerConfig().Delete(args[0]); err != nil {
+			}); err != nil {
 				return err
 			}
-			if err := client.SyncRemote(); err != nil {
-				return err
+			action := "archived"
+			if O.Server.Purge {
+				action = "deleted"
+			}
+			for _, p := range removedPeers {
+				fmt.Printf("peer %q %s, status=%v\n", p.UID, action, p.GetStatus())
 			}
-			fmt.Printf("wireguard server %q removed!\n", args[0])
+			fmt.Printf("wireguard server %q removed, %d peer(s) %s!\n", args[0], len(removedPeers), action)
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&O.Server.Cascade, "cascade", false, "Remove the peers of the server as well.")
+	cmd.Flags().BoolVar(&O.Server.Purge, "purge", false, "Delete the peers permanently instead of archiving them, used with --cascade.")
 	return cmd
 }
 
@@ -128,20 +163,9 @@ func InitServer() *cobra.Command {
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			wgenv := args[0]
-			client, err := storeclient.New(GlobalDBFile, GlobalBoltOptions)
+			ipmap, err := ipam.New(api.SplitAddresses(O.Server.Address)...)
 			if err != nil {
-				return err
-			}
-			wgsc, err := client.WireguardServerConfig().Get(wgenv)
-			if err != nil {
-				return err
-			}
-			if wgsc != nil && !O.Server.Override {
-				return fmt.Errorf("wireguard server config %q already exists", wgsc.UID)
-			}
-			addr := api.ParseCIDR(O.Server.Address)
-			if addr == nil {
-				return fmt.Errorf("ip address %q in wrong format", O.Server.Address)
+				return fmt.Errorf("ip address %q in wrong format: %v", O.Server.Address, err)
 			}
 			if !strings.Contains(O.Server.PublicEndpoint, ":") {
 				return fmt.Errorf("public endpoint %q invalid format", O.Server.PublicEndpoint)
@@ -154,45 +178,68 @@ func InitServer() *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("failed generating AES encryption key: %v", err)
 			}
-			encPrivKey, err := cipherKey.EncryptMessage(privKey.String())
+			encPrivKey, err := api.EncryptPrivateKey(privKey, cipherKey.String())
 			if err != nil {
-				return fmt.Errorf("failed encrypting private key: %v", err)
+				return err
 			}
 			pubKey := privKey.PublicKey()
-			if err := client.WireguardServerConfig().Update(&api.WireguardServerConfig{
-				Metadata: api.Metadata{
-					UID:       wgenv,
-					CreatedAt: time.Now().UTC().Format(time.RFC3339),
-				},
-				Address:             O.Server.Address,
-				PublicEndpoint:      O.Server.PublicEndpoint,
-				ListenPort:          O.Server.ListenPort,
-				EncryptedPrivateKey: encPrivKey,
-				PublicKey:           &pubKey,
-				PostUp: []string{
-					// https://github.com/StreisandEffect/streisand/issues/1089#issuecomment-350400689
-					fmt.Sprintf("ip link set mtu 1360 dev %s", O.Server.InterfaceName),
-					"ip link set mtu 1360 dev %i",
-
-					"sysctl -w net.ipv4.ip_forward=1",
-					"sysctl -w net.ipv6.conf.all.forwarding=1",
-					"iptables -A FORWARD -o %i -j ACCEPT",
-					"iptables -A FORWARD -i %i -j ACCEPT",
-					fmt.Sprintf("iptables -t nat -A POSTROUTING -o %s -j MASQUERADE", O.Server.InterfaceName),
-				},
-				PostDown: []string{
-					"sysctl -w net.ipv4.ip_forward=0",
-					"sysctl -w net.ipv6.conf.all.forwarding=0",
-					"iptables -D FORWARD -o %i -j ACCEPT",
-					"iptables -D FORWARD -i %i -j ACCEPT",
-					fmt.Sprintf("iptables -t nat -D POSTROUTING -o %s -j MASQUERADE", O.Server.InterfaceName),
-				},
+			if err := updateStore(func(client storeclient.Client) error {
+				wgsc, err := client.WireguardServerConfig().Get(wgenv)
+				if err != nil {
+					return err
+				}
+				if wgsc != nil && !O.Server.Override {
+					return fmt.Errorf("wireguard server config %q already exists", wgsc.UID)
+				}
+				wgsc = &api.WireguardServerConfig{
+					Metadata: api.Metadata{
+						UID:       wgenv,
+						CreatedAt: time.Now().UTC().Format(time.RFC3339),
+					},
+					Address:             O.Server.Address,
+					PublicEndpoint:      O.Server.PublicEndpoint,
+					ListenPort:          O.Server.ListenPort,
+					EncryptedPrivateKey: encPrivKey,
+					PublicKey:           &pubKey,
+					PostUp: []string{
+						// https://github.com/StreisandEffect/streisand/issues/1089#issuecomment-350400689
+						fmt.Sprintf("ip link set mtu 1360 dev %s", O.Server.InterfaceName),
+						"ip link set mtu 1360 dev %i",
+
+						"sysctl -w net.ipv4.ip_forward=1",
+						"sysctl -w net.ipv6.conf.all.forwarding=1",
+						"iptables -A FORWARD -o %i -j ACCEPT",
+						"iptables -A FORWARD -i %i -j ACCEPT",
+						fmt.Sprintf("iptables -t nat -A POSTROUTING -o %s -j MASQUERADE", O.Server.InterfaceName),
+					},
+					PostDown: []string{
+						"sysctl -w net.ipv4.ip_forward=0",
+						"sysctl -w net.ipv6.conf.all.forwarding=0",
+						"iptables -D FORWARD -o %i -j ACCEPT",
+						"iptables -D FORWARD -i %i -j ACCEPT",
+						fmt.Sprintf("iptables -t nat -D POSTROUTING -o %s -j MASQUERADE", O.Server.InterfaceName),
+					},
+				}
+				for _, a := range ipmap.Allocators {
+					if !a.IsIPv6() {
+						continue
+					}
+					wgsc.PostUp = append(wgsc.PostUp,
+						"ip6tables -A FORWARD -o %i -j ACCEPT",
+						"ip6tables -A FORWARD -i %i -j ACCEPT",
+						fmt.Sprintf("ip6tables -t nat -A POSTROUTING -o %s -j MASQUERADE", O.Server.InterfaceName),
+					)
+					wgsc.PostDown = append(wgsc.PostDown,
+						"ip6tables -D FORWARD -o %i -j ACCEPT",
+						"ip6tables -D FORWARD -i %i -j ACCEPT",
+						fmt.Sprintf("ip6tables -t nat -D POSTROUTING -o %s -j MASQUERADE", O.Server.InterfaceName),
+					)
+					break
+				}
+				return client.WireguardServerConfig().Update(wgsc)
 			}); err != nil {
 				return fmt.Errorf("failed creating wireguard server config: %v", err)
 			}
-			if err := client.SyncRemote(); err != nil {
-				return fmt.Errorf("failed syncing remote state: %v", err)
-			}
 			// THe Cipher Key was randomly generated, print to stdout
 			if O.Server.CipherKey == "" {
 				fmt.Println(cipherKey.String())
@@ -201,10 +248,408 @@ func InitServer() *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVar(&O.Server.InterfaceName, "iface", "eth0", "The name of the interface which will be used to run scripts.")
-	cmd.Flags().StringVar(&O.Server.Address, "address", "192.168.180.1/22", "The address of wireguard server config.")
+	cmd.Flags().StringVar(&O.Server.Address, "address", "192.168.180.1/22", "The comma separated addresses of wireguard server config, IPv4 and IPv6 networks are allowed.")
 	cmd.Flags().StringVar(&O.Server.PublicEndpoint, "endpoint", "", "The public [DNS|IP]:PORT for the wireguard server instance.")
 	cmd.Flags().StringVar(&O.Server.CipherKey, "cipher-key", os.Getenv("CIPHER_KEY"), "A base64 encoded key used to encrypt the private key, could be set using CIPHER_KEY environment variable.")
 	cmd.Flags().BoolVar(&O.Server.Override, "override", false, "Override the current configuration.")
 	cmd.Flags().IntVar(&O.Server.ListenPort, "listen-port", 51820, "The listen port for the wireguard server.")
 	return cmd
 }
+
+// validateServer verify if the attributes of a wireguard server config are valid,
+// the address must contain the addresses of all peers outside of the reserved ranges.
+func validateServer(wgsc *api.WireguardServerConfig, peers []api.Peer) error {
+	ipmap, err := newIPMap(wgsc)
+	if err != nil {
+		return fmt.Errorf("failed validating addresses: %v", err)
+	}
+	if !strings.Contains(wgsc.PublicEndpoint, ":") {
+		return fmt.Errorf("public endpoint %q invalid format", wgsc.PublicEndpoint)
+	}
+	if wgsc.ListenPort <= 0 || wgsc.ListenPort > 65535 {
+		return fmt.Errorf("listen port %d out of range", wgsc.ListenPort)
+	}
+	if err := validateRoutes(wgsc.ClientRoutes); err != nil {
+		return err
+	}
+	for _, p := range peers {
+		for _, ipaddr := range p.ParseAllowedIPs() {
+			if !ipmap.Contains(ipaddr) {
+				return fmt.Errorf("peer %s has ip %q which doesn't belong to network %v", p.UID, ipaddr.String(), ipmap.String())
+			}
+			// the reserved addresses can't be taken by peers
+			if err := ipmap.Allocate(ipaddr); err != nil {
+				return fmt.Errorf("peer %s has ip %q which isn't available: %v", p.UID, ipaddr.String(), err)
+			}
+		}
+		if err := validateSubnets(ipmap, peers, &p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateServerConfig validates and stores a modified wireguard server config, the keys,
+// the pending key rotation and the creation time are always preserved from the stored object.
+// It fails if the object was changed after the given updatedAt.
+func updateServerConfig(client storeclient.Client, updated *api.WireguardServerConfig, updatedAt string) error {
+	old, err := client.WireguardServerConfig().Get(updated.UID)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return fmt.Errorf("wireguard server %q not found", updated.UID)
+	}
+	if updatedAt != "" && old.UpdatedAt != updatedAt {
+		return fmt.Errorf("wireguard server %q was modified at %v, try again", updated.UID, old.UpdatedAt)
+	}
+	updated.Metadata.CreatedAt = old.Metadata.CreatedAt
+	updated.EncryptedPrivateKey = old.EncryptedPrivateKey
+	updated.PublicKey = old.PublicKey
+	updated.KeyRotation = old.KeyRotation
+	peers, err := client.Peer().ListByServer(updated.UID)
+	if err != nil {
+		return fmt.Errorf("failed listing peers: %v", err)
+	}
+	if err := validateServer(updated, peers); err != nil {
+		return err
+	}
+	return client.WireguardServerConfig().Update(updated)
+}
+
+// UpdateServer patches attributes of an existing wireguard server config
+func UpdateServer() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "update NAME",
+		Short:        "Update attributes of a wireguard server config preserving its keys.",
+		SilenceUsage: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return errors.New("missing the resource name")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			changed := false
+			for _, name := range []string{"address", "endpoint", "listen-port", "post-up", "post-down", "reserved", "pool", "route-subnets", "client-route"} {
+				changed = changed || flags.Changed(name)
+			}
+			if !changed {
+				return errors.New("nothing to update, specify at least one flag")
+			}
+			if err := updateStore(func(client storeclient.Client) error {
+				wgsc, err := client.WireguardServerConfig().Get(args[0])
+				if err != nil {
+					return err
+				}
+				if wgsc == nil {
+					return fmt.Errorf("wireguard server %q not found", args[0])
+				}
+				if flags.Changed("address") {
+					wgsc.Address, _ = flags.GetString("address")
+				}
+				if flags.Changed("endpoint") {
+					wgsc.PublicEndpoint, _ = flags.GetString("endpoint")
+				}
+				if flags.Changed("listen-port") {
+					wgsc.ListenPort, _ = flags.GetInt("listen-port")
+				}
+				if flags.Changed("post-up") {
+					wgsc.PostUp, _ = flags.GetStringArray("post-up")
+				}
+				if flags.Changed("post-down") {
+					wgsc.PostDown, _ = flags.GetStringArray("post-down")
+				}
+				if flags.Changed("reserved") {
+					wgsc.Reserved, _ = flags.GetStringArray("reserved")
+				}
+				if flags.Changed("pool") {
+					pools, _ := flags.GetStringArray("pool")
+					wgsc.Pools = nil
+					for _, pool := range pools {
+						parts := strings.SplitN(pool, "=", 2)
+						if len(parts) != 2 {
+							return fmt.Errorf("pool %q in wrong format, expected NAME=ADDRESSES", pool)
+						}
+						wgsc.Pools = append(wgsc.Pools, api.AddressPool{Name: parts[0], Address: parts[1]})
+					}
+				}
+				if flags.Changed("client-route") {
+					wgsc.ClientRoutes, _ = flags.GetStringArray("client-route")
+				}
+				if flags.Changed("route-subnets") {
+					wgsc.RouteSubnets, _ = flags.GetBool("route-subnets")
+				}
+				return updateServerConfig(client, wgsc, "")
+			}); err != nil {
+				return err
+			}
+			fmt.Printf("wireguard server %q updated!\n", args[0])
+			return nil
+		},
+	}
+	// the flags aren't bound to the global options, otherwise
+	// their defaults would override the ones of the init command
+	cmd.Flags().String("address", "", "The comma separated addresses of wireguard server config, IPv4 and IPv6 networks are allowed.")
+	cmd.Flags().String("endpoint", "", "The public [DNS|IP]:PORT for the wireguard server instance.")
+	cmd.Flags().Int("listen-port", 51820, "The listen port for the wireguard server.")
+	cmd.Flags().StringArray("post-up", nil, "Replace the PostUp commands, could be specified multiple times.")
+	cmd.Flags().StringArray("post-down", nil, "Replace the PostDown commands, could be specified multiple times.")
+	cmd.Flags().StringArray("reserved", nil, "Replace the addresses or networks never allocated to peers, could be specified multiple times.")
+	cmd.Flags().StringArray("client-route", nil, "Replace the networks routed through the tunnel by the clients, could be specified multiple times. Defaults to all the traffic.")
+	cmd.Flags().Bool("route-subnets", false, "Route the subnets of the active peers through the interface, the routes are managed by the peer daemon.")
+	cmd.Flags().StringArray("pool", nil, "Replace the pools of addresses as NAME=ADDRESSES, could be specified multiple times.")
+	return cmd
+}
+
+// EditServer edit a wireguard server config using the default editor
+func EditServer() *cobra.Command {
+	return &cobra.Command{
+		Use:          "edit NAME",
+		Short:        "Edit a wireguard server config with the default editor ($EDITOR).",
+		SilenceUsage: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return errors.New("missing the resource name")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newStoreClient()
+			if err != nil {
+				return err
+			}
+			wgsc, err := client.WireguardServerConfig().Get(args[0])
+			client.Close()
+			if err != nil {
+				return err
+			}
+			if wgsc == nil {
+				return fmt.Errorf("wireguard server %q not found", args[0])
+			}
+			original, err := yaml.Marshal(wgsc)
+			if err != nil {
+				return err
+			}
+			edited, err := editInEditor(fmt.Sprintf("wgadmin-%s-", args[0]), original)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(original, edited) {
+				fmt.Println("Edit cancelled, no changes made.")
+				return nil
+			}
+			var updated api.WireguardServerConfig
+			if err := yaml.Unmarshal(edited, &updated); err != nil {
+				return fmt.Errorf("failed parsing edited config: %v", err)
+			}
+			if updated.UID != wgsc.UID {
+				return errors.New("the uid of the wireguard server config can't be changed")
+			}
+			if err := updateStore(func(client storeclient.Client) error {
+				obj := updated
+				return updateServerConfig(client, &obj, wgsc.UpdatedAt)
+			}); err != nil {
+				return err
+			}
+			fmt.Printf("wireguard server %q edited!\n", args[0])
+			return nil
+		},
+	}
+}
+
+// RotateServerKey generates a new key pair for a wireguard server, the current keys
+// are replaced by the daemons at the switch time and kept during a grace period.
+func RotateServerKey() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "rotate-key NAME",
+		Short:        "Rotate the key pair of a wireguard server config.",
+		SilenceUsage: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return errors.New("missing the resource name")
+			}
+			if O.Server.Complete && O.Server.Cancel {
+				return errors.New("--complete and --cancel are mutually exclusive")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := time.Now().UTC()
+			switchAt := now.Add(O.Server.SwitchIn)
+			if O.Server.SwitchAt != "" {
+				t, err := time.Parse(time.RFC3339, O.Server.SwitchAt)
+				if err != nil {
+					return fmt.Errorf("failed parsing switch time: %v", err)
+				}
+				switchAt = t.UTC()
+			}
+			var msg string
+			if err := updateStore(func(client storeclient.Client) error {
+				wgsc, err := client.WireguardServerConfig().Get(args[0])
+				if err != nil {
+					return err
+				}
+				if wgsc == nil {
+					return fmt.Errorf("wireguard server %q not found", args[0])
+				}
+				switch {
+				case O.Server.Cancel:
+					if wgsc.KeyRotation == nil {
+						return fmt.Errorf("wireguard server %q doesn't have a key rotation in progress", args[0])
+					}
+					// peers which renewed after the switch have configs with the new key
+					if wgsc.KeyRotation.IsSwitched(now) {
+						if err := markPeersRenewConfig(client, wgsc.UID); err != nil {
+							return err
+						}
+					}
+					wgsc.KeyRotation = nil
+					msg = fmt.Sprintf("key rotation of wireguard server %q cancelled!", args[0])
+				case O.Server.Complete:
+					if !wgsc.CompleteKeyRotation(now) {
+						return fmt.Errorf("wireguard server %q doesn't have a switched key rotation", args[0])
+					}
+					msg = fmt.Sprintf("key rotation of wireguard server %q completed!", args[0])
+				default:
+					if wgsc.KeyRotation != nil {
+						if !wgsc.KeyRotation.IsComplete(now) {
+							return fmt.Errorf("wireguard server %q has a key rotation in progress, use --complete or --cancel", args[0])
+						}
+						wgsc.CompleteKeyRotation(now)
+					}
+					// the new key must be readable by the daemons
+					if _, err := wgsc.DecryptPrivateKey(O.Server.CipherKey); err != nil {
+						return fmt.Errorf("cipher key doesn't match the current one: %v", err)
+					}
+					privKey, err := api.GeneratePrivateKey()
+					if err != nil {
+						return fmt.Errorf("failed generating private key: %v", err)
+					}
+					encPrivKey, err := api.EncryptPrivateKey(privKey, O.Server.CipherKey)
+					if err != nil {
+						return err
+					}
+					pubKey := privKey.PublicKey()
+					wgsc.KeyRotation = &api.ServerKeyRotation{
+						EncryptedPrivateKey: encPrivKey,
+						PublicKey:           &pubKey,
+						SwitchAt:            switchAt.Format(time.RFC3339),
+						GracePeriod:         O.Server.GracePeriod.String(),
+					}
+					if err := markPeersRenewConfig(client, wgsc.UID); err != nil {
+						return err
+					}
+					msg = fmt.Sprintf("wireguard server %q will use the public key %s at %s!", args[0], pubKey.String(), wgsc.KeyRotation.SwitchAt)
+				}
+				return client.WireguardServerConfig().Update(wgsc)
+			}); err != nil {
+				return err
+			}
+			fmt.Println(msg)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&O.Server.CipherKey, "cipher-key", os.Getenv("CIPHER_KEY"), "The base64 encoded key used to encrypt the private key, could be set using CIPHER_KEY environment variable.")
+	cmd.Flags().StringVar(&O.Server.SwitchAt, "switch-at", "", "The RFC3339 time when the daemons start using the new key.")
+	cmd.Flags().DurationVar(&O.Server.SwitchIn, "switch-in", 0, "The duration to wait before the daemons start using the new key.")
+	cmd.Flags().DurationVar(&O.Server.GracePeriod, "grace-period", 24*time.Hour, "How long to keep the previous key after the switch, allowing to cancel the rotation. The sync-servers daemon discards it afterwards.")
+	cmd.Flags().BoolVar(&O.Server.Complete, "complete", false, "Discard the previous key of a switched rotation.")
+	cmd.Flags().BoolVar(&O.Server.Cancel, "cancel", false, "Cancel the rotation restoring the previous key.")
+	return cmd
+}
+
+// RotateCipherKey re-encrypts the private keys of all servers with a new cipher key
+func RotateCipherKey() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-cipher-key",
+		Short: "Re-encrypt the private keys of all wireguard servers with a new cipher key.",
+		Long: `Re-encrypt the private keys of all wireguard servers with a new cipher key.
+
+The new key must be added to the cipherKeys of the server daemons before rotating,
+after the rotation the daemons could use it as the cipherKey and drop the old one.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if O.Server.OldCipherKey == "" || O.Server.NewCipherKey == "" {
+				return errors.New("missing the --old or --new cipher key")
+			}
+			if O.Server.OldCipherKey == O.Server.NewCipherKey {
+				return errors.New("the new cipher key must be different from the old one")
+			}
+			newCipherKey, err := util.NewAESCipherKey(O.Server.NewCipherKey)
+			if err != nil {
+				return fmt.Errorf("failed parsing the new cipher key: %v", err)
+			}
+			var updated int
+			if err := updateStore(func(client storeclient.Client) (err error) {
+				updated, err = client.WireguardServerConfig().UpdateAll(func(wgsc *api.WireguardServerConfig) error {
+					return wgsc.ReEncryptPrivateKeys(O.Server.OldCipherKey, O.Server.NewCipherKey)
+				})
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed rotating cipher key: %v", err)
+			}
+			fmt.Printf("%d wireguard server(s) encrypted with the cipher key %s!\n", updated, newCipherKey.ID())
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&O.Server.OldCipherKey, "old", os.Getenv("CIPHER_KEY"), "The base64 encoded key currently encrypting the private keys, could be set using CIPHER_KEY environment variable.")
+	cmd.Flags().StringVar(&O.Server.NewCipherKey, "new", "", "The base64 encoded key to encrypt the private keys.")
+	return cmd
+}
+
+// MigrateEncryption re-encrypts the private keys of all servers using the current encryption format
+func MigrateEncryption() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "migrate-encryption",
+		Short:        "Re-encrypt the private keys stored in older encryption formats using the current one.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if O.Server.CipherKey == "" {
+				return errors.New("missing the cipher key")
+			}
+			var migrated []string
+			var total int
+			if err := updateStore(func(client storeclient.Client) (err error) {
+				migrated = nil
+				total, err = client.WireguardServerConfig().UpdateAll(func(wgsc *api.WireguardServerConfig) error {
+					ok, err := wgsc.MigrateEncryption(O.Server.CipherKey)
+					if ok {
+						migrated = append(migrated, wgsc.UID)
+					}
+					return err
+				})
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed migrating encryption: %v", err)
+			}
+			for _, uid := range migrated {
+				fmt.Printf("wireguard server %q migrated\n", uid)
+			}
+			fmt.Printf("%d of %d wireguard server(s) migrated to the encryption version %s!\n", len(migrated), total, util.CipherVersionGCM)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&O.Server.CipherKey, "cipher-key", os.Getenv("CIPHER_KEY"), "The base64 encoded key used to encrypt the private key, could be set using CIPHER_KEY environment variable.")
+	return cmd
+}
+
+// markPeersRenewConfig marks all peers without persistent public keys
+// as requiring a new client config
+func markPeersRenewConfig(client storeclient.Client, server string) error {
+	peerList, err := client.Peer().ListByServer(server)
+	if err != nil {
+		return fmt.Errorf("failed listing peers: %v", err)
+	}
+	for _, p := range peerList {
+		if p.Spec.PersistentPublicKey != nil || p.Status.RenewConfig {
+			continue
+		}
+		p.Status.RenewConfig = true
+		if err := client.Peer().UpdateStatus(&p); err != nil {
+			return fmt.Errorf("failed updating peer %s: %v", p.UID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sandromello/wgadmin/pkg/api"
+	storeclient "github.com/sandromello/wgadmin/pkg/store/client"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTempFile(path string, flag int, mode os.FileMode) (*os.File, error) {
+	f, err := ioutil.TempFile("", "wgadmin-")
+	if err != nil {
+		return nil, err
+	}
+	return f, os.Remove(f.Name())
+}
+
+func TestUpdateServerConfigPreservesKeys(t *testing.T) {
+	client := storeclient.NewOrDie("", "", &bolt.Options{OpenFile: openTempFile})
+	defer client.Close()
+	privKey, _ := api.GeneratePrivateKey()
+	newPrivKey, _ := api.GeneratePrivateKey()
+	pubKey, newPubKey := privKey.PublicKey(), newPrivKey.PublicKey()
+	rotation := &api.ServerKeyRotation{
+		EncryptedPrivateKey: newPrivKey.String(),
+		PublicKey:           &newPubKey,
+		SwitchAt:            "2020-01-02T00:00:00Z",
+		GracePeriod:         "24h0m0s",
+	}
+	wgsc := &api.WireguardServerConfig{
+		Metadata:            api.Metadata{UID: "dev", CreatedAt: "2020-01-01T00:00:00Z"},
+		Address:             "10.100.0.1/24",
+		ListenPort:          51820,
+		PublicEndpoint:      "1.2.3.4:51820",
+		EncryptedPrivateKey: privKey.String(),
+		PublicKey:           &pubKey,
+		KeyRotation:         rotation,
+	}
+	if err := client.WireguardServerConfig().Update(wgsc); err != nil {
+		t.Fatalf("failed storing wireguard server config: %v", err)
+	}
+	editedPrivKey, _ := api.GeneratePrivateKey()
+	for _, tt := range []struct {
+		name        string
+		keyRotation *api.ServerKeyRotation
+	}{
+		{name: "removed key rotation"},
+		{name: "edited key rotation", keyRotation: &api.ServerKeyRotation{EncryptedPrivateKey: editedPrivKey.String()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := *wgsc
+			updated.ListenPort = 51821
+			updated.EncryptedPrivateKey = editedPrivKey.String()
+			updated.KeyRotation = tt.keyRotation
+			if err := updateServerConfig(client, &updated, ""); err != nil {
+				t.Fatalf("failed updating wireguard server config: %v", err)
+			}
+			got, err := client.WireguardServerConfig().Get("dev")
+			if err != nil {
+				t.Fatalf("failed retrieving wireguard server config: %v", err)
+			}
+			if got.ListenPort != 51821 {
+				t.Fatalf("expected listen port 51821, got %d", got.ListenPort)
+			}
+			if got.EncryptedPrivateKey != wgsc.EncryptedPrivateKey {
+				t.Fatalf("expected the private key to be preserved")
+			}
+			if diff := cmp.Diff(rotation, got.KeyRotation); diff != "" {
+				t.Fatalf("unexpected key rotation (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
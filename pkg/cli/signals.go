@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+var daemonSignals = map[os.Signal]daemonAction{
+	os.Interrupt:    daemonTerminate,
+	syscall.SIGTERM: daemonTerminate,
+	syscall.SIGHUP:  daemonReload,
+	syscall.SIGUSR1: daemonReconcile,
+}
@@ -0,0 +1,12 @@
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// SIGHUP and SIGUSR1 aren't delivered on windows
+var daemonSignals = map[os.Signal]daemonAction{
+	os.Interrupt:    daemonTerminate,
+	syscall.SIGTERM: daemonTerminate,
+}
@@ -1,18 +1,64 @@
 package cli
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/user"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 // PersistentPreRunE will execute on every subcommand call
 func PersistentPreRunE(cmd *cobra.Command, args []string) error {
-	if O.Local {
-		GlobalBoltOptions = InitEmptyBoltOptions()
-	}
 	if _, err := os.Stat(GlobalWGAppConfigPath); !os.IsNotExist(err) {
 		return nil
 	}
 	return os.Mkdir(GlobalWGAppConfigPath, 0755)
 }
+
+// currentUser identifies who is running the command as <user>@<hostname>
+func currentUser() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return username
+	}
+	return fmt.Sprintf("%s@%s", username, hostname)
+}
+
+// editInEditor opens the data in a temporary file using $EDITOR and
+// returns the edited content
+func editInEditor(pattern string, data []byte) ([]byte, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	f, err := ioutil.TempFile("", pattern+"*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	// the editor could have arguments, e.g.: code --wait
+	editorArgs := strings.Fields(editor)
+	cmd := exec.Command(editorArgs[0], append(editorArgs[1:], f.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed running editor %q: %v", editor, err)
+	}
+	return ioutil.ReadFile(f.Name())
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/gorilla/securecookie"
 	"github.com/sandromello/wgadmin/pkg/api"
+	storeclient "github.com/sandromello/wgadmin/pkg/store/client"
 	"github.com/sandromello/wgadmin/pkg/webapp"
 	"github.com/spf13/cobra"
 )
@@ -23,6 +25,69 @@ func parseWebAppConfigFile(path string) (*api.WebApp, error) {
 	return &c, yaml.Unmarshal(data, &c)
 }
 
+// loadSessionKeyPairs decodes the session keys of the config and the keys file,
+// a random key is generated if none is configured.
+func loadSessionKeyPairs(c *api.WebApp) ([][]byte, error) {
+	sessionKeys := c.SessionKeys
+	if c.SessionKeysFile != "" {
+		data, err := ioutil.ReadFile(c.SessionKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		var fileKeys []api.SessionKey
+		if err := yaml.Unmarshal(data, &fileKeys); err != nil {
+			return nil, fmt.Errorf("failed parsing session keys file: %v", err)
+		}
+		sessionKeys = append(sessionKeys, fileKeys...)
+	}
+	if len(sessionKeys) == 0 {
+		log.Printf("Session keys aren't configured, sessions will be lost on restarts")
+		sessionKey := securecookie.GenerateRandomKey(32)
+		if sessionKey == nil {
+			return nil, fmt.Errorf("failed generating session key")
+		}
+		return [][]byte{sessionKey}, nil
+	}
+	var keyPairs [][]byte
+	for i, k := range sessionKeys {
+		hashKey, blockKey, err := k.KeyPair()
+		if err != nil {
+			return nil, fmt.Errorf("invalid session key #%d: %v", i, err)
+		}
+		keyPairs = append(keyPairs, hashKey, blockKey)
+	}
+	return keyPairs, nil
+}
+
+// NewSessionKey generates a session key pair to use in the webapp config
+func NewSessionKey() *cobra.Command {
+	return &cobra.Command{
+		Use:   "new-session-key",
+		Short: "Generate a random session key pair to use in the sessionKeys of the webapp config.",
+		Long: `Generate a random session key pair to use in the sessionKeys of the webapp config.
+
+To rotate the keys, add the new pair as the first item of the list. New sessions
+are signed with the first pair, the old ones could be removed after the sessions expire.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hashKey := securecookie.GenerateRandomKey(64)
+			blockKey := securecookie.GenerateRandomKey(32)
+			if hashKey == nil || blockKey == nil {
+				return fmt.Errorf("failed generating session key")
+			}
+			data, err := yaml.Marshal([]api.SessionKey{{
+				HashKey:  base64.StdEncoding.EncodeToString(hashKey),
+				BlockKey: base64.StdEncoding.EncodeToString(blockKey),
+			}})
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(data))
+			return nil
+		},
+	}
+}
+
 // RunWebServerCmd start the webserver
 // https://console.developers.google.com/apis/dashboard
 func RunWebServerCmd() *cobra.Command {
@@ -44,13 +109,22 @@ func RunWebServerCmd() *cobra.Command {
 			fs := http.FileServer(http.Dir(staticDir))
 			mux.Handle("/static/", http.StripPrefix("/static/", fs))
 
-			sessionKey := securecookie.GenerateRandomKey(32)
-			if sessionKey == nil {
-				return fmt.Errorf("failed generating session key")
+			keyPairs, err := loadSessionKeyPairs(webappc)
+			if err != nil {
+				return fmt.Errorf("failed loading session keys: %v", err)
+			}
+			remote, err := storeclient.NewRemoteBackend(webappc.GetRemoteConfig())
+			if err != nil {
+				return fmt.Errorf("failed initializing remote backend: %v", err)
+			}
+			handler, err := webapp.NewHandler(keyPairs, webappc, remote)
+			if err != nil {
+				return fmt.Errorf("failed initializing webapp handler: %v", err)
 			}
-			handler := webapp.NewHandler(sessionKey, webappc.PageConfig, webappc.AllowedDomains)
 			mux.HandleFunc("/", handler.Index)
 			mux.HandleFunc("/signin", handler.Signin)
+			mux.HandleFunc("/oidc/login", handler.OIDCLogin)
+			mux.HandleFunc("/oidc/callback", handler.OIDCCallback)
 			mux.HandleFunc("/signout/", handler.Signout)
 			mux.HandleFunc("/peers/", handler.Peers)
 			address := fmt.Sprintf(":%s", webappc.HTTPPort)
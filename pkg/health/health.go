@@ -0,0 +1,89 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Report is the state of the reconciles of a daemon
+type Report struct {
+	Ready               bool   `json:"ready"`
+	LastSuccessAt       string `json:"lastSuccessAt,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+	LastErrorAt         string `json:"lastErrorAt,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+// Status tracks the reconciles of a daemon, it's ready while
+// the last successful reconcile isn't older than the stale threshold
+type Status struct {
+	staleAfter time.Duration
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastError   error
+	lastErrorAt time.Time
+	failures    int
+}
+
+// NewStatus creates a status which isn't ready until the first successful reconcile
+func NewStatus(staleAfter time.Duration) *Status {
+	return &Status{staleAfter: staleAfter}
+}
+
+// Observe records the result of a reconcile
+func (s *Status) Observe(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	if err != nil {
+		s.lastError = err
+		s.lastErrorAt = now
+		s.failures++
+		return
+	}
+	s.lastSuccess = now
+	s.failures = 0
+}
+
+// Report returns the current state of the reconciles
+func (s *Status) Report() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Report{
+		Ready:               !s.lastSuccess.IsZero() && time.Since(s.lastSuccess) <= s.staleAfter,
+		ConsecutiveFailures: s.failures,
+	}
+	if !s.lastSuccess.IsZero() {
+		r.LastSuccessAt = s.lastSuccess.Format(time.RFC3339)
+	}
+	if s.lastError != nil {
+		r.LastError = s.lastError.Error()
+		r.LastErrorAt = s.lastErrorAt.Format(time.RFC3339)
+	}
+	return r
+}
+
+// Healthz reports the state of the reconciles, it always succeeds while the daemon is running
+func (s *Status) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, s.Report())
+}
+
+// Readyz reports the state of the reconciles, it fails if the daemon never
+// reconciled successfully or the last successful reconcile is stale
+func (s *Status) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := s.Report()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report *Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
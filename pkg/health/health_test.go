@@ -0,0 +1,67 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	s := NewStatus(time.Minute)
+	for _, tt := range []struct {
+		name       string
+		observe    func()
+		statusCode int
+		failures   int
+	}{
+		{
+			name:       "never reconciled",
+			observe:    func() {},
+			statusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "successful reconcile",
+			observe:    func() { s.Observe(nil) },
+			statusCode: http.StatusOK,
+		},
+		{
+			name: "failures within the threshold",
+			observe: func() {
+				s.Observe(errors.New("failed listing peers"))
+				s.Observe(errors.New("failed listing peers"))
+			},
+			statusCode: http.StatusOK,
+			failures:   2,
+		},
+		{
+			name:       "stale reconcile",
+			observe:    func() { s.lastSuccess = time.Now().Add(-2 * time.Minute) },
+			statusCode: http.StatusServiceUnavailable,
+			failures:   2,
+		},
+		{
+			name:       "recovered",
+			observe:    func() { s.Observe(nil) },
+			statusCode: http.StatusOK,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.observe()
+			rec := httptest.NewRecorder()
+			s.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+			if rec.Code != tt.statusCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.statusCode, rec.Code, rec.Body)
+			}
+			if report := s.Report(); report.ConsecutiveFailures != tt.failures {
+				t.Fatalf("expected %d consecutive failures, got %#v", tt.failures, report)
+			}
+			rec = httptest.NewRecorder()
+			s.Healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, rec.Code)
+			}
+		})
+	}
+}
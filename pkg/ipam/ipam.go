@@ -0,0 +1,322 @@
+package ipam
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+)
+
+// maxBitmapBits limits the addresses allocated dynamically, larger networks
+// (e.g.: IPv6) allocate only from the first 2^24 addresses of the network or
+// of a pool. Addresses beyond the bitmap are tracked sparsely.
+const maxBitmapBits = 1 << 24
+
+// Allocator assigns the addresses of a network from the lowest free one.
+// The network and broadcast addresses of IPv4 networks, the subnet-router
+// anycast address of IPv6 networks, the gateway and the reserved ranges
+// are never allocated. The ranges of pools are allocated only from their pool.
+type Allocator struct {
+	Net *net.IPNet
+
+	base  *big.Int
+	first uint64
+	last  uint64
+	// used is a bitmap of the allocated offsets from the network address,
+	// it grows up to the highest offset allocated
+	used     []uint64
+	sparse   map[uint64]bool
+	reserved []span
+	pools    map[string][]span
+}
+
+// span is an inclusive range of offsets
+type span struct {
+	first, last uint64
+}
+
+func findSpan(spans []span, off uint64) (span, bool) {
+	for _, s := range spans {
+		if off >= s.first && off <= s.last {
+			return s, true
+		}
+	}
+	return span{}, false
+}
+
+// NewAllocator creates an allocator for the network of the given address,
+// the address is the gateway of the network, e.g.: 10.100.0.1/24. A gateway
+// outside of the allocatable range (e.g.: 10.100.0.0/24) is never allocated already.
+func NewAllocator(address string) (*Allocator, error) {
+	gateway, ipnet, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, err
+	}
+	a := newAllocator(ipnet)
+	if _, ok := a.offset(gateway); !ok {
+		return a, nil
+	}
+	if err := a.Allocate(gateway); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func newAllocator(ipnet *net.IPNet) *Allocator {
+	if ip4 := ipnet.IP.To4(); ip4 != nil {
+		ipnet = &net.IPNet{IP: ip4, Mask: ipnet.Mask[len(ipnet.Mask)-net.IPv4len:]}
+	}
+	ones, size := ipnet.Mask.Size()
+	hostBits := uint(size - ones)
+	a := &Allocator{
+		Net:    ipnet,
+		base:   new(big.Int).SetBytes(ipnet.IP),
+		last:   ^uint64(0),
+		sparse: map[uint64]bool{},
+		pools:  map[string][]span{},
+	}
+	if hostBits < 64 {
+		a.last = 1<<hostBits - 1
+	}
+	switch {
+	case size == 8*net.IPv4len && hostBits > 1:
+		// network and broadcast addresses, /31 and /32 don't have them (RFC 3021)
+		a.first, a.last = 1, a.last-1
+	case size == 8*net.IPv6len && hostBits > 0:
+		// subnet-router anycast address (RFC 4291)
+		a.first = 1
+	}
+	return a
+}
+
+// offset returns the position of ip in the network, it's false if
+// the ip doesn't belong to the allocatable range of the network
+func (a *Allocator) offset(ip net.IP) (uint64, bool) {
+	off, ok := a.rawOffset(ip)
+	if !ok || off < a.first || off > a.last {
+		return 0, false
+	}
+	return off, true
+}
+
+func (a *Allocator) rawOffset(ip net.IP) (uint64, bool) {
+	if ip4 := ip.To4(); ip4 != nil && len(a.Net.IP) == net.IPv4len {
+		ip = ip4
+	}
+	if len(ip) != len(a.Net.IP) || !a.Net.Contains(ip) {
+		return 0, false
+	}
+	off := new(big.Int).Sub(new(big.Int).SetBytes(ip), a.base)
+	if !off.IsUint64() {
+		return 0, false
+	}
+	return off.Uint64(), true
+}
+
+// span returns the allocatable offsets of a network inside of the network of the allocator
+func (a *Allocator) span(ipnet *net.IPNet) (span, error) {
+	ones, size := ipnet.Mask.Size()
+	netOnes, netSize := a.Net.Mask.Size()
+	first, ok := a.rawOffset(ipnet.IP.Mask(ipnet.Mask))
+	if !ok || size != netSize || ones < netOnes {
+		return span{}, fmt.Errorf("network %v doesn't belong to network %v", ipnet, a.Net)
+	}
+	s := span{first: first, last: ^uint64(0)}
+	if hostBits := uint(size - ones); hostBits < 64 {
+		s.last = first + (1<<hostBits - 1)
+	}
+	if s.first < a.first {
+		s.first = a.first
+	}
+	if s.last > a.last {
+		s.last = a.last
+	}
+	return s, nil
+}
+
+// Reserve excludes a range of addresses from any allocation
+func (a *Allocator) Reserve(ipnet *net.IPNet) error {
+	s, err := a.span(ipnet)
+	if err != nil {
+		return err
+	}
+	a.reserved = append(a.reserved, s)
+	return nil
+}
+
+// AddPool sets a range of addresses apart, they are allocated only with PopPool
+func (a *Allocator) AddPool(name string, ipnet *net.IPNet) error {
+	s, err := a.span(ipnet)
+	if err != nil {
+		return err
+	}
+	for poolName, spans := range a.pools {
+		for _, other := range spans {
+			if s.first <= other.last && other.first <= s.last {
+				return fmt.Errorf("network %v of pool %q overlaps with pool %q", ipnet, name, poolName)
+			}
+		}
+	}
+	a.pools[name] = append(a.pools[name], s)
+	return nil
+}
+
+// HasPool returns true if the pool has a range in the network
+func (a *Allocator) HasPool(name string) bool {
+	_, ok := a.pools[name]
+	return ok
+}
+
+func (a *Allocator) ip(off uint64) net.IP {
+	b := new(big.Int).Add(a.base, new(big.Int).SetUint64(off)).Bytes()
+	ip := make(net.IP, len(a.Net.IP))
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
+
+func (a *Allocator) isSet(off uint64) bool {
+	if off >= maxBitmapBits {
+		return a.sparse[off]
+	}
+	w := off / 64
+	return w < uint64(len(a.used)) && a.used[w]&(1<<(off%64)) != 0
+}
+
+func (a *Allocator) set(off uint64, used bool) {
+	if off >= maxBitmapBits {
+		if used {
+			a.sparse[off] = true
+		} else {
+			delete(a.sparse, off)
+		}
+		return
+	}
+	w := off / 64
+	if w >= uint64(len(a.used)) {
+		if !used {
+			return
+		}
+		a.used = append(a.used, make([]uint64, w+1-uint64(len(a.used)))...)
+	}
+	if used {
+		a.used[w] |= 1 << (off % 64)
+	} else {
+		a.used[w] &^= 1 << (off % 64)
+	}
+}
+
+// IsAvailable returns true if the ip belongs to the network and it's not allocated or reserved
+func (a *Allocator) IsAvailable(ip net.IP) bool {
+	off, ok := a.offset(ip)
+	if !ok {
+		return false
+	}
+	_, reserved := findSpan(a.reserved, off)
+	return !reserved && !a.isSet(off)
+}
+
+// Allocate marks the ip as used, it fails if it's not available
+func (a *Allocator) Allocate(ip net.IP) error {
+	off, ok := a.offset(ip)
+	if !ok {
+		return fmt.Errorf("ip %v isn't allocatable in network %v", ip, a.Net)
+	}
+	if _, reserved := findSpan(a.reserved, off); reserved {
+		return fmt.Errorf("ip %v is reserved", ip)
+	}
+	if a.isSet(off) {
+		return fmt.Errorf("ip %v is already allocated", ip)
+	}
+	a.set(off, true)
+	return nil
+}
+
+// Release frees an allocated ip, it's a noop if it isn't allocated
+func (a *Allocator) Release(ip net.IP) {
+	if off, ok := a.offset(ip); ok {
+		a.set(off, false)
+	}
+}
+
+// Pop allocates the lowest free ip outside of the reserved ranges and pools
+// returning it as a host network (/32 or /128), it returns nil when the
+// network is exhausted.
+func (a *Allocator) Pop() *net.IPNet {
+	exclude := a.reserved
+	for _, spans := range a.pools {
+		exclude = append(exclude[:len(exclude):len(exclude)], spans...)
+	}
+	return a.popRange(span{a.first, a.last}, exclude)
+}
+
+// PopPool allocates the lowest free ip of a pool, it returns nil
+// when the pool is exhausted or it doesn't have a range in the network.
+func (a *Allocator) PopPool(name string) *net.IPNet {
+	for _, s := range a.pools[name] {
+		if ipnet := a.popRange(s, a.reserved); ipnet != nil {
+			return ipnet
+		}
+	}
+	return nil
+}
+
+func (a *Allocator) popRange(r span, exclude []span) *net.IPNet {
+	if r.last-r.first >= maxBitmapBits {
+		r.last = r.first + maxBitmapBits - 1
+	}
+	off := r.first
+	for {
+		next, ok := a.nextFree(off, r.last)
+		if !ok {
+			return nil
+		}
+		s, excluded := findSpan(exclude, next)
+		if !excluded {
+			return a.pop(next)
+		}
+		if s.last >= r.last {
+			return nil
+		}
+		off = s.last + 1
+	}
+}
+
+// nextFree returns the lowest offset not allocated between off and last
+func (a *Allocator) nextFree(off, last uint64) (uint64, bool) {
+	for off <= last {
+		if off >= maxBitmapBits {
+			// the sparse offsets are allocated one by one from the start of the range
+			for a.sparse[off] {
+				if off == last {
+					return 0, false
+				}
+				off++
+			}
+			return off, true
+		}
+		w := off / 64
+		if w >= uint64(len(a.used)) {
+			return off, true
+		}
+		// the free bits of the word starting at off
+		free := ^a.used[w] &^ (1<<(off%64) - 1)
+		if free == 0 {
+			off = (w + 1) * 64
+			continue
+		}
+		off = w*64 + uint64(bits.TrailingZeros64(free))
+		return off, off <= last
+	}
+	return 0, false
+}
+
+// IsIPv6 returns true if the network is an IPv6 network
+func (a *Allocator) IsIPv6() bool {
+	return len(a.Net.IP) == net.IPv6len
+}
+
+func (a *Allocator) pop(off uint64) *net.IPNet {
+	a.set(off, true)
+	size := 8 * len(a.Net.IP)
+	return &net.IPNet{IP: a.ip(off), Mask: net.CIDRMask(size, size)}
+}
@@ -0,0 +1,240 @@
+package ipam
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func popAll(a *Allocator, max int) []string {
+	var ips []string
+	for i := 0; i < max; i++ {
+		ipnet := a.Pop()
+		if ipnet == nil {
+			break
+		}
+		ips = append(ips, ipnet.String())
+	}
+	return ips
+}
+
+func TestAllocatorPop(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		address string
+		max     int
+		want    []string
+	}{
+		{
+			name:    "/30 excludes network, broadcast and gateway",
+			address: "10.0.0.1/30",
+			max:     10,
+			want:    []string{"10.0.0.2/32"},
+		},
+		{
+			name:    "/31 has no network or broadcast address",
+			address: "10.0.0.0/31",
+			max:     10,
+			want:    []string{"10.0.0.1/32"},
+		},
+		{
+			name:    "/29 with the gateway in the middle",
+			address: "192.168.0.3/29",
+			max:     10,
+			want:    []string{"192.168.0.1/32", "192.168.0.2/32", "192.168.0.4/32", "192.168.0.5/32", "192.168.0.6/32"},
+		},
+		{
+			name:    "/16 allocates addresses ending in zero",
+			address: "10.100.0.1/16",
+			max:     255,
+			want:    append(hostRange(t, "10.100.0.2", 254), "10.100.1.0/32"),
+		},
+		{
+			name:    "gateway at the network address",
+			address: "10.0.0.0/30",
+			max:     10,
+			want:    []string{"10.0.0.1/32", "10.0.0.2/32"},
+		},
+		{
+			name:    "ipv6 gateway at the subnet-router anycast address",
+			address: "fd00::/126",
+			max:     10,
+			want:    []string{"fd00::1/128", "fd00::2/128", "fd00::3/128"},
+		},
+		{
+			name:    "/8 allocates the lowest free addresses",
+			address: "10.0.0.1/8",
+			max:     2,
+			want:    []string{"10.0.0.2/32", "10.0.0.3/32"},
+		},
+		{
+			name:    "ipv6 skips the subnet-router anycast address",
+			address: "fd00::1/64",
+			max:     2,
+			want:    []string{"fd00::2/128", "fd00::3/128"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAllocator(tt.address)
+			if err != nil {
+				t.Fatalf("failed creating allocator: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, popAll(a, tt.max)); diff != "" {
+				t.Fatalf("unexpected ips (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func hostRange(t *testing.T, start string, n int) []string {
+	ip := net.ParseIP(start).To4()
+	if ip == nil {
+		t.Fatalf("failed parsing ip %q", start)
+	}
+	var ips []string
+	for i := 0; i < n; i++ {
+		ips = append(ips, (&net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}).String())
+		ip = net.IP{ip[0], ip[1], ip[2], ip[3] + 1}
+	}
+	return ips
+}
+
+func TestAllocatorAllocateRelease(t *testing.T) {
+	a, err := NewAllocator("10.0.0.1/24")
+	if err != nil {
+		t.Fatalf("failed creating allocator: %v", err)
+	}
+	for _, ip := range []string{"10.0.0.0", "10.0.0.1", "10.0.0.255", "10.0.1.2"} {
+		if a.IsAvailable(net.ParseIP(ip)) {
+			t.Fatalf("expected ip %s to not be available", ip)
+		}
+		if err := a.Allocate(net.ParseIP(ip)); err == nil {
+			t.Fatalf("expected an error allocating ip %s", ip)
+		}
+	}
+	if err := a.Allocate(net.ParseIP("10.0.0.2")); err != nil {
+		t.Fatalf("failed allocating ip: %v", err)
+	}
+	if err := a.Allocate(net.ParseIP("10.0.0.2")); err == nil {
+		t.Fatalf("expected an error allocating the same ip twice")
+	}
+	if got := a.Pop().String(); got != "10.0.0.3/32" {
+		t.Fatalf("expected to pop 10.0.0.3/32, got %s", got)
+	}
+	a.Release(net.ParseIP("10.0.0.2"))
+	if got := a.Pop().String(); got != "10.0.0.2/32" {
+		t.Fatalf("expected to pop the released ip 10.0.0.2/32, got %s", got)
+	}
+}
+
+func TestMapPop(t *testing.T) {
+	m, err := New("10.0.0.1/30", "fd00::1/64")
+	if err != nil {
+		t.Fatalf("failed creating map: %v", err)
+	}
+	var got []string
+	ipnets, err := m.Pop()
+	if err != nil {
+		t.Fatalf("failed allocating ips: %v", err)
+	}
+	for _, ipnet := range ipnets {
+		got = append(got, ipnet.String())
+	}
+	if diff := cmp.Diff([]string{"10.0.0.2/32", "fd00::2/128"}, got); diff != "" {
+		t.Fatalf("unexpected ips (-want +got):\n%s", diff)
+	}
+	// the ipv4 network is exhausted, an explicit ipv4 address skips it
+	if _, err := m.Pop(); err == nil {
+		t.Fatalf("expected an error allocating from an exhausted network")
+	}
+	ipnets, err = m.Pop(net.ParseIP("10.0.0.2"))
+	if err != nil {
+		t.Fatalf("failed allocating ips: %v", err)
+	}
+	if len(ipnets) != 1 || !m.Contains(ipnets[0].IP) || ipnets[0].IP.To4() != nil {
+		t.Fatalf("expected to allocate only an ipv6 address, got %v", ipnets)
+	}
+	if _, err := New("10.0.0.1/24", "10.0.0.129/25"); err == nil {
+		t.Fatalf("expected an error creating a map with overlapping networks")
+	}
+}
+
+func TestMapReservedAndPools(t *testing.T) {
+	m, err := New("10.0.0.1/24")
+	if err != nil {
+		t.Fatalf("failed creating map: %v", err)
+	}
+	for _, address := range []string{"10.0.0.2", "10.0.0.4/30"} {
+		ipnet, err := ParseNetwork(address)
+		if err != nil {
+			t.Fatalf("failed parsing network: %v", err)
+		}
+		if err := m.Reserve(ipnet); err != nil {
+			t.Fatalf("failed reserving network: %v", err)
+		}
+	}
+	_, pool, _ := net.ParseCIDR("10.0.0.8/30")
+	if err := m.AddPool("infra", pool); err != nil {
+		t.Fatalf("failed adding pool: %v", err)
+	}
+	if m.IsAvailable(net.ParseIP("10.0.0.5")) {
+		t.Fatalf("expected reserved ip 10.0.0.5 to not be available")
+	}
+	var got []string
+	for i := 0; i < 3; i++ {
+		ipnets, err := m.Pop()
+		if err != nil {
+			t.Fatalf("failed allocating ips: %v", err)
+		}
+		got = append(got, ipnets[0].String())
+	}
+	if diff := cmp.Diff([]string{"10.0.0.3/32", "10.0.0.12/32", "10.0.0.13/32"}, got); diff != "" {
+		t.Fatalf("unexpected ips (-want +got):\n%s", diff)
+	}
+	got = nil
+	for i := 0; i < 4; i++ {
+		ipnets, err := m.PopPool("infra")
+		if err != nil {
+			break
+		}
+		got = append(got, ipnets[0].String())
+	}
+	if diff := cmp.Diff([]string{"10.0.0.8/32", "10.0.0.9/32", "10.0.0.10/32", "10.0.0.11/32"}, got); diff != "" {
+		t.Fatalf("unexpected pool ips (-want +got):\n%s", diff)
+	}
+	if _, err := m.PopPool("infra"); err == nil {
+		t.Fatalf("expected an error allocating from an exhausted pool")
+	}
+	if _, err := m.PopPool("unknown"); err == nil {
+		t.Fatalf("expected an error allocating from an unknown pool")
+	}
+}
+
+func TestMapPoolBeyondTheBitmap(t *testing.T) {
+	m, err := New("10.100.0.1/24", "fd00:100::1/64")
+	if err != nil {
+		t.Fatalf("failed creating map: %v", err)
+	}
+	_, pool, _ := net.ParseCIDR("fd00:100::1:0:0/96")
+	if err := m.AddPool("eng", pool); err != nil {
+		t.Fatalf("failed adding pool: %v", err)
+	}
+	if err := m.Allocate(net.ParseIP("fd00:100::1:0:1")); err != nil {
+		t.Fatalf("failed allocating ip: %v", err)
+	}
+	var got []string
+	for i := 0; i < 2; i++ {
+		ipnets, err := m.PopPool("eng")
+		if err != nil {
+			t.Fatalf("failed allocating pool ips: %v", err)
+		}
+		for _, ipnet := range ipnets {
+			got = append(got, ipnet.String())
+		}
+	}
+	want := []string{"10.100.0.2/32", "fd00:100::1:0:0/128", "10.100.0.3/32", "fd00:100::1:0:2/128"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected pool ips (-want +got):\n%s", diff)
+	}
+}
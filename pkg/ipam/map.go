@@ -0,0 +1,179 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Map allocates addresses from the networks of a wireguard server,
+// a server could have IPv4 and IPv6 networks (dual-stack).
+type Map struct {
+	Allocators []*Allocator
+}
+
+// New creates a map for the given addresses, e.g.: 10.100.0.1/24, fd00::1/64
+func New(addresses ...string) (*Map, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("missing addresses")
+	}
+	m := &Map{}
+	for _, address := range addresses {
+		a, err := NewAllocator(address)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range m.Allocators {
+			if Overlaps(other.Net, a.Net) {
+				return nil, fmt.Errorf("network %v overlaps with %v", a.Net, other.Net)
+			}
+		}
+		m.Allocators = append(m.Allocators, a)
+	}
+	return m, nil
+}
+
+func (m *Map) allocator(ip net.IP) *Allocator {
+	for _, a := range m.Allocators {
+		if a.Net.Contains(ip) {
+			return a
+		}
+	}
+	return nil
+}
+
+// Contains returns true if the ip belongs to any of the networks
+func (m *Map) Contains(ip net.IP) bool {
+	return m.allocator(ip) != nil
+}
+
+// IsAvailable returns true if the ip belongs to a network and it's not allocated
+func (m *Map) IsAvailable(ip net.IP) bool {
+	a := m.allocator(ip)
+	return a != nil && a.IsAvailable(ip)
+}
+
+// Allocate marks the ip as used, it fails if it's not available
+func (m *Map) Allocate(ip net.IP) error {
+	a := m.allocator(ip)
+	if a == nil {
+		return fmt.Errorf("ip %v doesn't belong to network %v", ip, m)
+	}
+	return a.Allocate(ip)
+}
+
+// Release frees an allocated ip
+func (m *Map) Release(ip net.IP) {
+	if a := m.allocator(ip); a != nil {
+		a.Release(ip)
+	}
+}
+
+// Reserve excludes a range of addresses from any allocation
+func (m *Map) Reserve(ipnet *net.IPNet) error {
+	a := m.allocator(ipnet.IP)
+	if a == nil {
+		return fmt.Errorf("network %v doesn't belong to network %v", ipnet, m)
+	}
+	return a.Reserve(ipnet)
+}
+
+// AddPool sets a range of addresses apart for the pool name
+func (m *Map) AddPool(name string, ipnet *net.IPNet) error {
+	a := m.allocator(ipnet.IP)
+	if a == nil {
+		return fmt.Errorf("network %v of pool %q doesn't belong to network %v", ipnet, name, m)
+	}
+	return a.AddPool(name, ipnet)
+}
+
+// Pop allocates the lowest free address of each family (IPv4 and IPv6)
+// of the networks, the families of the skip ips aren't allocated.
+// It fails if a family doesn't have any free address.
+func (m *Map) Pop(skip ...net.IP) ([]*net.IPNet, error) {
+	return m.pop("", skip)
+}
+
+// PopPool allocates the lowest free address of each family from a pool,
+// the families without a range in the pool are allocated as in Pop.
+func (m *Map) PopPool(name string, skip ...net.IP) ([]*net.IPNet, error) {
+	found := false
+	for _, a := range m.Allocators {
+		found = found || a.HasPool(name)
+	}
+	if !found {
+		return nil, fmt.Errorf("pool %q not found", name)
+	}
+	return m.pop(name, skip)
+}
+
+func (m *Map) pop(pool string, skip []net.IP) ([]*net.IPNet, error) {
+	done := map[bool]bool{}
+	for _, ip := range skip {
+		done[ip.To4() == nil] = true
+	}
+	// the ranges of the pool are used first for its families
+	poolFamilies := map[bool]bool{}
+	for _, a := range m.Allocators {
+		if pool != "" && a.HasPool(pool) {
+			poolFamilies[a.IsIPv6()] = true
+		}
+	}
+	var ipnets []*net.IPNet
+	for _, a := range m.Allocators {
+		if done[a.IsIPv6()] {
+			continue
+		}
+		var ipnet *net.IPNet
+		switch {
+		case !poolFamilies[a.IsIPv6()]:
+			ipnet = a.Pop()
+		case a.HasPool(pool):
+			ipnet = a.PopPool(pool)
+		}
+		if ipnet != nil {
+			ipnets = append(ipnets, ipnet)
+			done[a.IsIPv6()] = true
+		}
+	}
+	for _, a := range m.Allocators {
+		if done[a.IsIPv6()] {
+			continue
+		}
+		if poolFamilies[a.IsIPv6()] {
+			return nil, fmt.Errorf("reach maximum allocation for pool %q", pool)
+		}
+		return nil, fmt.Errorf("reach maximum allocation for network %v", a.Net)
+	}
+	return ipnets, nil
+}
+
+// String returns the networks separated by comma
+func (m *Map) String() string {
+	var networks []string
+	for _, a := range m.Allocators {
+		networks = append(networks, a.Net.String())
+	}
+	return strings.Join(networks, ", ")
+}
+
+// ParseNetwork parses a network in CIDR notation or a single ip
+func ParseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// Overlaps returns true if the networks share any address
+func Overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/wgtools"
+)
+
+const namespace = "wgadmin"
+
+// Daemon names used as the value of the daemon label
+const (
+	DaemonServer = "server"
+	DaemonPeer   = "peer"
+)
+
+var (
+	// ReconcileDuration observes the duration of each reconcile
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciles of the daemon.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"daemon"})
+	// ReconcileErrors counts the failed reconciles
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed reconciles of the daemon.",
+	}, []string{"daemon"})
+	// DirtyPeers is the number of peer changes which failed to be applied
+	DirtyPeers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dirty_peers",
+		Help:      "Number of peer changes which failed to be applied in the last reconcile.",
+	})
+	// DesiredPeers is the number of peers of the server in the store
+	DesiredPeers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "desired_peers",
+		Help:      "Number of peers of the server in the store.",
+	})
+	// ActualPeers is the number of peers found in the wireguard interface
+	ActualPeers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "actual_peers",
+		Help:      "Number of peers found in the wireguard interface.",
+	})
+
+	peers    = &peerCollector{}
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ReconcileDuration,
+		ReconcileErrors,
+		DirtyPeers,
+		DesiredPeers,
+		ActualPeers,
+		peers,
+	)
+}
+
+// Handler serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveReconcile records the duration and the result of a reconcile
+func ObserveReconcile(daemon string, start time.Time, err error) {
+	ReconcileDuration.WithLabelValues(daemon).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ReconcileErrors.WithLabelValues(daemon).Inc()
+	}
+}
+
+// SetPeers replaces the peers exported with their handshake age and traffic,
+// the local peers are identified by the uid of the desired peers.
+func SetPeers(desiredPeers []api.Peer, localPeers []wgtools.Peer) {
+	uids := map[string]string{}
+	for _, p := range desiredPeers {
+		uids[p.PublicKeyString()] = p.UID
+	}
+	stats := make([]peerStats, 0, len(localPeers))
+	for _, p := range localPeers {
+		stats = append(stats, peerStats{uid: uids[p.PublicKey], Peer: p})
+	}
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+	peers.stats = stats
+}
+
+type peerStats struct {
+	wgtools.Peer
+	uid string
+}
+
+var (
+	peerLabels        = []string{"peer", "public_key"}
+	peerHandshakeDesc = prometheus.NewDesc(namespace+"_peer_last_handshake_seconds", "Seconds since the last handshake of the peer.", peerLabels, nil)
+	peerReceiveDesc   = prometheus.NewDesc(namespace+"_peer_receive_bytes_total", "Bytes received from the peer.", peerLabels, nil)
+	peerTransmitDesc  = prometheus.NewDesc(namespace+"_peer_transmit_bytes_total", "Bytes sent to the peer.", peerLabels, nil)
+)
+
+// peerCollector exports the stats of the last listed peers,
+// the handshake age is computed when the metrics are collected.
+type peerCollector struct {
+	mu    sync.Mutex
+	stats []peerStats
+}
+
+func (c *peerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peerHandshakeDesc
+	ch <- peerReceiveDesc
+	ch <- peerTransmitDesc
+}
+
+func (c *peerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.stats {
+		if !s.LastHandshake.IsZero() {
+			ch <- prometheus.MustNewConstMetric(peerHandshakeDesc, prometheus.GaugeValue,
+				time.Since(s.LastHandshake).Seconds(), s.uid, s.PublicKey)
+		}
+		ch <- prometheus.MustNewConstMetric(peerReceiveDesc, prometheus.CounterValue,
+			float64(s.ReceiveBytes), s.uid, s.PublicKey)
+		ch <- prometheus.MustNewConstMetric(peerTransmitDesc, prometheus.CounterValue,
+			float64(s.TransmitBytes), s.uid, s.PublicKey)
+	}
+}
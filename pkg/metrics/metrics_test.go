@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/wgtools"
+)
+
+func TestPeerMetrics(t *testing.T) {
+	pubKey := api.Key{1}
+	SetPeers([]api.Peer{
+		{Metadata: api.Metadata{UID: "dev/foo"}, Status: api.PeerStatus{PublicKey: &pubKey}},
+	}, []wgtools.Peer{
+		{PublicKey: pubKey.String(), LastHandshake: time.Now().Add(-time.Minute), ReceiveBytes: 2048, TransmitBytes: 4096},
+		{PublicKey: "unknown"},
+	})
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`wgadmin_peer_receive_bytes_total{peer="dev/foo",public_key="` + pubKey.String() + `"} 2048`,
+		`wgadmin_peer_transmit_bytes_total{peer="dev/foo",public_key="` + pubKey.String() + `"} 4096`,
+		`wgadmin_peer_last_handshake_seconds{peer="dev/foo",public_key="` + pubKey.String() + `"} 60`,
+		`wgadmin_peer_receive_bytes_total{peer="",public_key="unknown"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metric %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `wgadmin_peer_last_handshake_seconds{peer="",public_key="unknown"}`) {
+		t.Fatalf("unexpected handshake age of a peer which never connected")
+	}
+}
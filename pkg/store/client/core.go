@@ -1,29 +1,28 @@
 package client
 
 import (
-	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"strings"
-	"time"
+	"path/filepath"
 
-	"cloud.google.com/go/storage"
 	"github.com/sandromello/wgadmin/pkg/store"
 	bolt "go.etcd.io/bbolt"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
 )
 
 const (
-	wgserverPrefix      string = "/wgsconfig"
-	peerPrefix          string = "/peers"
-	bucketName          string = "wireguard"
-	gcsTimeoutInSeconds        = 10
+	wgserverPrefix    string = "/wgsconfig"
+	peerPrefix        string = "/peers"
+	archivePeerPrefix string = "/archive/peers"
+	bucketName        string = "wireguard"
+
+	syncRemoteRetries = 5
 )
 
+// MutateFn applies changes to the store, it may be called more than
+// once when the remote database is modified concurrently
+type MutateFn func(c Client) error
+
 // Client objects to interact with store
 type Client interface {
 	WireguardServerConfig() WireguardServerConfig
@@ -35,7 +34,7 @@ type Client interface {
 type coreClient struct {
 	wireguardServerConfig *wireguardServerConfig
 	peer                  *peer
-	bucket                string
+	remote                RemoteBackend
 }
 
 // WireguardServerConfig creates a client to interact with wg server config
@@ -53,115 +52,96 @@ func (c *coreClient) Close() error {
 
 func (c *coreClient) SyncRemote() error {
 	// don't sync if the store isn't remote
-	if !c.peer.store.IsRemote {
+	if c.remote == nil {
 		return nil
 	}
 	dbfile := c.peer.store.Path()
 	if err := c.Close(); err != nil {
 		return err
 	}
-	d := time.Now().Add(10 * time.Second)
-	ctx, cancel := context.WithDeadline(context.Background(), d)
-	defer cancel()
-	creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadWrite)
-	if err != nil {
-		log.Fatal(err)
-	}
-	storageClient, err := storage.NewClient(ctx, option.WithCredentials(creds))
-	if err != nil {
-		return err
-	}
-	gcsBucketName, err := getBucketEnvName()
-	if err != nil {
-		return err
-	}
-	w := storageClient.Bucket(gcsBucketName).
-		Object(store.DBFileName).
-		NewWriter(ctx)
-	f, err := os.Open(dbfile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if _, err = io.Copy(w, f); err != nil {
-		return err
-	}
-	return w.Close()
+	return uploadToRemote(c.remote, dbfile, c.peer.store.RemoteGeneration)
 }
 
-// New initializes the store or returns an error
-func New(dbfile string, opts *bolt.Options) (Client, error) {
+// New initializes the store or returns an error. If a remote backend is given
+// the database is fetched from it and SyncRemote will upload it back.
+func New(dbfile string, remote RemoteBackend, opts *bolt.Options) (Client, error) {
+	var generation string
+	if remote != nil {
+		o := bolt.Options{}
+		if opts != nil {
+			o = *opts
+		}
+		o.OpenFile = fetchFromRemote(remote, &generation)
+		opts = &o
+	}
 	db, err := store.New(dbfile, bucketName, opts)
 	if err != nil {
 		return nil, err
 	}
+	db.RemoteGeneration = generation
 	return &coreClient{
+		remote: remote,
 		wireguardServerConfig: &wireguardServerConfig{
-			store:  db,
-			prefix: wgserverPrefix,
+			store:             db,
+			prefix:            wgserverPrefix,
+			peerPrefix:        peerPrefix,
+			archivePeerPrefix: archivePeerPrefix,
 		},
 		peer: &peer{
-			store:  db,
-			prefix: peerPrefix,
+			store:         db,
+			prefix:        peerPrefix,
+			archivePrefix: archivePeerPrefix,
 		},
 	}, db.CreateBucketIfNotExists(bucketName)
 }
 
 // NewOrDie initializes the store or die (panic)
 func NewOrDie(dbfile, bucket string, opts *bolt.Options) Client {
-	c, err := New(dbfile, opts)
+	c, err := New(dbfile, nil, opts)
 	if err != nil {
 		panic(err)
 	}
 	return c
 }
 
-func getBucketEnvName() (string, error) {
-	gcsBucketName := os.Getenv("GCS_BUCKET_NAME")
-	if gcsBucketName == "" {
-		return "", fmt.Errorf("GCS_BUCKET_NAME env not set or empty")
+// Update opens the store, applies fn and syncs it with the remote backend.
+// If the remote database was modified since it was fetched, the store is
+// fetched again and fn is replayed until it succeeds or the retries are exhausted.
+// With a remote backend each attempt fetches the database to a temporary file next
+// to dbfile, thus processes sharing dbfile never upload a copy written by another one.
+func Update(dbfile string, remote RemoteBackend, opts *bolt.Options, fn MutateFn) error {
+	if remote == nil {
+		return update(dbfile, nil, opts, fn)
+	}
+	for attempt := 1; ; attempt++ {
+		err := updateTemp(dbfile, remote, opts, fn)
+		if err != ErrConflict || attempt == syncRemoteRetries {
+			return err
+		}
 	}
-	return gcsBucketName, nil
 }
 
-// FetchFromGCS fetch store from GCS, it must be passed as function in bolt.Options.OpenFile
-func FetchFromGCS(path string, flag int, mode os.FileMode) (*os.File, error) {
-	ctx, _ := context.WithTimeout(context.Background(), gcsTimeoutInSeconds*time.Second)
-	creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
-	if err != nil {
-		return nil, err
-	}
-	storageClient, err := storage.NewClient(ctx, option.WithCredentials(creds))
+func updateTemp(dbfile string, remote RemoteBackend, opts *bolt.Options, fn MutateFn) error {
+	f, err := ioutil.TempFile(filepath.Dir(dbfile), filepath.Base(dbfile)+".update-")
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed creating temporary database: %v", err)
 	}
-	gcsBucketName, err := getBucketEnvName()
+	f.Close()
+	defer os.Remove(f.Name())
+	return update(f.Name(), remote, opts, fn)
+}
+
+func update(dbfile string, remote RemoteBackend, opts *bolt.Options, fn MutateFn) error {
+	c, err := New(dbfile, remote, opts)
 	if err != nil {
-		return nil, err
-	}
-	// Check if the bucket exists
-	b := storageClient.Bucket(gcsBucketName)
-	if _, err := b.Attrs(ctx); err != nil {
-		return nil, err
-	}
-	rc, err := b.Object(store.DBFileName).NewReader(ctx)
-	if err != nil && strings.Contains(err.Error(), "object doesn't exist") {
-		return os.Create(path)
+		return err
 	}
-	if err != nil {
-		return nil, err
+	if err := fn(c); err != nil {
+		c.Close()
+		return err
 	}
-	defer rc.Close()
-	data, err := ioutil.ReadAll(rc)
-	f, err := os.Create(path)
-	if err != nil {
-		return nil, err
+	if remote == nil {
+		return c.Close()
 	}
-	_, err = f.Write(data)
-	return f, err
-}
-
-// NewGCS initializes the store from a Google Cloud Storage bucket
-func NewGCS(dbfile string) (Client, error) {
-	return New(dbfile, &bolt.Options{OpenFile: FetchFromGCS})
+	return c.SyncRemote()
 }
@@ -9,21 +9,26 @@ import (
 
 	"github.com/sandromello/wgadmin/pkg/api"
 	"github.com/sandromello/wgadmin/pkg/store"
+	bolt "go.etcd.io/bbolt"
 )
 
 // Peer methods to interact with store
 type Peer interface {
 	Get(name string) (*api.Peer, error)
 	Update(obj *api.Peer) error
+	UpdateStatus(obj *api.Peer) error
 	Delete(name string) error
 	List() ([]api.Peer, error)
 	ListByServer(prefix string) ([]api.Peer, error)
 	SearchByPubKey(server, pubkey string) (*api.Peer, error)
+	Archive(name, deletedBy string) (*api.Peer, error)
+	ListArchived(server string) ([]api.Peer, error)
 }
 
 type peer struct {
-	store  *store.Database
-	prefix string
+	store         *store.Database
+	prefix        string
+	archivePrefix string
 }
 
 // Get retrieves a peer by its name
@@ -71,6 +76,16 @@ func (c *peer) Update(obj *api.Peer) error {
 	return c.store.Set(path.Join(c.prefix, obj.UID), jsonData)
 }
 
+// UpdateStatus stores a peer without changing its .metadata.updatedAt attribute,
+// the expiration of peers is calculated from it and must not be affected by status changes
+func (c *peer) UpdateStatus(obj *api.Peer) error {
+	jsonData, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.store.Set(path.Join(c.prefix, obj.UID), jsonData)
+}
+
 // Delete the object by its name
 func (c *peer) Delete(name string) error {
 	key := path.Join(c.prefix, name)
@@ -92,9 +107,19 @@ func (c *peer) List() ([]api.Peer, error) {
 
 // ListByServer all the peer objects from a given server
 func (c *peer) ListByServer(server string) ([]api.Peer, error) {
+	return c.listByServer(c.prefix, server)
+}
+
+// ListArchived all the archived peer objects from a given server
+func (c *peer) ListArchived(server string) ([]api.Peer, error) {
+	return c.listByServer(c.archivePrefix, server)
+}
+
+func (c *peer) listByServer(prefix, server string) ([]api.Peer, error) {
 	var peers []api.Peer
 	pattern := fmt.Sprintf("%s/.+", server)
-	serverPrefix := fmt.Sprintf("%s/%s", c.prefix, server)
+	// the trailing slash prevents matching servers sharing the same prefix
+	serverPrefix := path.Join(prefix, server) + "/"
 	return peers, c.store.Search(serverPrefix, regexp.MustCompile(pattern), func(k, v []byte) error {
 		var obj api.Peer
 		if err := json.Unmarshal(v, &obj); err != nil {
@@ -104,3 +129,41 @@ func (c *peer) ListByServer(server string) ([]api.Peer, error) {
 		return nil
 	})
 }
+
+// Archive removes the peer and keeps a record of it in the archive,
+// the operation happens in a single transaction. It returns nil if the peer doesn't exist.
+func (c *peer) Archive(name, deletedBy string) (*api.Peer, error) {
+	var obj *api.Peer
+	return obj, c.store.Transaction(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.store.GetBucket()))
+		if b == nil {
+			return nil
+		}
+		var err error
+		obj, err = archivePeer(b, c.prefix, c.archivePrefix, name, deletedBy)
+		return err
+	})
+}
+
+// archivePeer moves a peer to the archive prefix inside a bolt transaction
+func archivePeer(b *bolt.Bucket, prefix, archivePrefix, name, deletedBy string) (*api.Peer, error) {
+	key := []byte(path.Join(prefix, name))
+	data := b.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	var obj api.Peer
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	obj.DeletedAt = time.Now().UTC().Format(time.RFC3339)
+	obj.DeletedBy = deletedBy
+	archived, err := json.Marshal(&obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Put([]byte(path.Join(archivePrefix, name)), archived); err != nil {
+		return nil, err
+	}
+	return &obj, b.Delete(key)
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sandromello/wgadmin/pkg/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+func peerUIDs(peers []api.Peer) []string {
+	var uids []string
+	for _, p := range peers {
+		uids = append(uids, p.UID)
+	}
+	return uids
+}
+
+func TestPeerArchive(t *testing.T) {
+	c := NewOrDie("", "", &bolt.Options{OpenFile: openTempFile})
+	for _, uid := range []string{"dev/bar", "dev/foo", "dev-a/foo"} {
+		if err := c.Peer().Update(&api.Peer{
+			Metadata: api.Metadata{UID: uid},
+			Spec:     api.PeerSpec{AllowedIPs: "10.0.0.2/32"},
+		}); err != nil {
+			t.Fatalf("failed creating peer: %v", err)
+		}
+	}
+	archived, err := c.Peer().Archive("dev/foo", "admin@localhost")
+	if err != nil {
+		t.Fatalf("failed archiving peer: %v", err)
+	}
+	if archived == nil || archived.DeletedBy != "admin@localhost" || archived.DeletedAt == "" {
+		t.Fatalf("unexpected archived peer: %#v", archived)
+	}
+	notFound, err := c.Peer().Archive("dev/foo", "admin@localhost")
+	if err != nil || notFound != nil {
+		t.Fatalf("expected to not find the archived peer, got %#v, err=%v", notFound, err)
+	}
+
+	peers, err := c.Peer().ListByServer("dev")
+	if err != nil {
+		t.Fatalf("failed listing peers: %v", err)
+	}
+	if diff := cmp.Diff([]string{"dev/bar"}, peerUIDs(peers)); diff != "" {
+		t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+	}
+	archivedPeers, err := c.Peer().ListArchived("dev")
+	if err != nil {
+		t.Fatalf("failed listing archived peers: %v", err)
+	}
+	if diff := cmp.Diff([]string{"dev/foo"}, peerUIDs(archivedPeers)); diff != "" {
+		t.Fatalf("unexpected archived peers (-want +got):\n%s", diff)
+	}
+	peers, err = c.Peer().List()
+	if err != nil {
+		t.Fatalf("failed listing peers: %v", err)
+	}
+	if diff := cmp.Diff([]string{"dev-a/foo", "dev/bar"}, peerUIDs(peers)); diff != "" {
+		t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+	}
+}
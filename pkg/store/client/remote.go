@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/store"
+)
+
+const remoteTimeoutInSeconds = 10
+
+var (
+	// ErrObjectNotExist is returned by a remote backend when the database wasn't uploaded yet
+	ErrObjectNotExist = errors.New("remote object doesn't exist")
+	// ErrConflict is returned when uploading a database which was modified
+	// by someone else since it was fetched
+	ErrConflict = errors.New("remote database was modified concurrently")
+)
+
+// ObjectAttrs represents the metadata of the database stored in a remote backend
+type ObjectAttrs struct {
+	Size    int64
+	Updated time.Time
+	// Generation identifies a version of the object (GCS generation, S3 ETag, ...)
+	Generation string
+}
+
+// RemoteBackend fetch and upload the database from a remote location
+type RemoteBackend interface {
+	// Fetch writes the content of the remote database to w
+	Fetch(ctx context.Context, w io.Writer) (*ObjectAttrs, error)
+	// Upload overwrites the remote database with the content of r only if the
+	// remote generation matches the given one, an empty generation means
+	// that the object must not exist. It returns ErrConflict otherwise.
+	Upload(ctx context.Context, r io.Reader, generation string) (*ObjectAttrs, error)
+	// Stat retrieves the metadata of the remote database
+	Stat(ctx context.Context) (*ObjectAttrs, error)
+}
+
+// NewRemoteBackend creates a remote backend based on the given config
+func NewRemoteBackend(c *api.RemoteConfig) (RemoteBackend, error) {
+	if c == nil {
+		return nil, fmt.Errorf("remote config is empty")
+	}
+	objectName := c.ObjectName
+	if objectName == "" {
+		objectName = store.DBFileName
+	}
+	switch c.Type {
+	case api.RemoteBackendGCS, "":
+		if c.BucketName == "" {
+			return nil, fmt.Errorf("bucket name not set or empty for gcs backend")
+		}
+		return &gcsBackend{bucket: c.BucketName, object: objectName}, nil
+	case api.RemoteBackendS3:
+		if c.BucketName == "" {
+			return nil, fmt.Errorf("bucket name not set or empty for s3 backend")
+		}
+		return newS3Backend(c.BucketName, objectName, c.S3)
+	case api.RemoteBackendLocal:
+		if c.Path == "" {
+			return nil, fmt.Errorf("path not set or empty for local backend")
+		}
+		return &localBackend{path: c.Path, object: objectName}, nil
+	}
+	return nil, fmt.Errorf("unknown remote backend type %q", c.Type)
+}
+
+// fetchFromRemote fetch the store from a remote backend, it must be passed as function
+// in bolt.Options.OpenFile. The generation of the fetched object is stored in gen.
+func fetchFromRemote(backend RemoteBackend, gen *string) func(string, int, os.FileMode) (*os.File, error) {
+	return func(path string, flag int, mode os.FileMode) (*os.File, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), remoteTimeoutInSeconds*time.Second)
+		defer cancel()
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := backend.Fetch(ctx, f)
+		switch {
+		case err == ErrObjectNotExist:
+			*gen = ""
+		case err != nil:
+			f.Close()
+			return nil, err
+		default:
+			*gen = attrs.Generation
+		}
+		return f, nil
+	}
+}
+
+// uploadToRemote uploads the database file to a remote backend
+// if the remote object still has the given generation
+func uploadToRemote(backend RemoteBackend, dbfile, generation string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeoutInSeconds*time.Second)
+	defer cancel()
+	f, err := os.Open(dbfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = backend.Upload(ctx, f, generation)
+	return err
+}
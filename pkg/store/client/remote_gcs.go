@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// gcsBackend stores the database in a Google Cloud Storage bucket,
+// credentials are resolved using the default google credentials chain
+type gcsBackend struct {
+	bucket string
+	object string
+}
+
+func (b *gcsBackend) newBucketHandle(ctx context.Context, scope string) (*storage.BucketHandle, error) {
+	creds, err := google.FindDefaultCredentials(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	storageClient, err := storage.NewClient(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return storageClient.Bucket(b.bucket), nil
+}
+
+func (b *gcsBackend) Fetch(ctx context.Context, w io.Writer) (*ObjectAttrs, error) {
+	bh, err := b.newBucketHandle(ctx, storage.ScopeReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	// Check if the bucket exists
+	if _, err := bh.Attrs(ctx); err != nil {
+		return nil, err
+	}
+	rc, err := bh.Object(b.object).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if _, err := io.Copy(w, rc); err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{
+		Size:       rc.Attrs.Size,
+		Updated:    rc.Attrs.LastModified,
+		Generation: strconv.FormatInt(rc.Attrs.Generation, 10),
+	}, nil
+}
+
+func (b *gcsBackend) Upload(ctx context.Context, r io.Reader, generation string) (*ObjectAttrs, error) {
+	bh, err := b.newBucketHandle(ctx, storage.ScopeReadWrite)
+	if err != nil {
+		return nil, err
+	}
+	cond := storage.Conditions{DoesNotExist: true}
+	if generation != "" {
+		gen, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gcs generation %q: %v", generation, err)
+		}
+		cond = storage.Conditions{GenerationMatch: gen}
+	}
+	w := bh.Object(b.object).If(cond).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusPreconditionFailed {
+			return nil, ErrConflict
+		}
+		return nil, err
+	}
+	return newGCSObjectAttrs(w.Attrs()), nil
+}
+
+func newGCSObjectAttrs(attrs *storage.ObjectAttrs) *ObjectAttrs {
+	return &ObjectAttrs{
+		Size:       attrs.Size,
+		Updated:    attrs.Updated,
+		Generation: strconv.FormatInt(attrs.Generation, 10),
+	}
+}
+
+func (b *gcsBackend) Stat(ctx context.Context) (*ObjectAttrs, error) {
+	bh, err := b.newBucketHandle(ctx, storage.ScopeReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := bh.Object(b.object).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newGCSObjectAttrs(attrs), nil
+}
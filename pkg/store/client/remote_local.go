@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// localBackend stores the database in a local directory,
+// useful for testing or when the directory is a shared mount.
+// The generation of the object is the sha256 sum of its content.
+type localBackend struct {
+	path   string
+	object string
+}
+
+func (b *localBackend) objectPath() string {
+	return filepath.Join(b.path, b.object)
+}
+
+// lockStaleAfter is the age of a lock file left behind by a crashed process,
+// the lock is held only while checking the generation and renaming the database.
+const lockStaleAfter = remoteTimeoutInSeconds * time.Second
+
+// lock creates a lock file exclusively, it's portable across platforms
+// and guards the generation check and the rename on Upload. The lock file
+// holds the pid of its owner and it's broken once it's older than lockStaleAfter.
+func (b *localBackend) lock(ctx context.Context) (func(), error) {
+	lockFile := b.objectPath() + ".lock"
+	for {
+		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lockFile); err == nil && time.Since(fi.ModTime()) > lockStaleAfter {
+			os.Remove(lockFile)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (b *localBackend) Fetch(ctx context.Context, w io.Writer) (*ObjectAttrs, error) {
+	f, err := os.Open(b.objectPath())
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), f); err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{
+		Size:       fi.Size(),
+		Updated:    fi.ModTime().UTC(),
+		Generation: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func (b *localBackend) Upload(ctx context.Context, r io.Reader, generation string) (*ObjectAttrs, error) {
+	if err := os.MkdirAll(b.path, 0755); err != nil {
+		return nil, err
+	}
+	unlock, err := b.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	attrs, err := b.Stat(ctx)
+	switch {
+	case err == ErrObjectNotExist:
+		if generation != "" {
+			return nil, ErrConflict
+		}
+	case err != nil:
+		return nil, err
+	case attrs.Generation != generation:
+		return nil, ErrConflict
+	}
+	// write to a temporary file first, the rename is atomic
+	tmp, err := ioutil.TempFile(b.path, b.object+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), b.objectPath()); err != nil {
+		return nil, err
+	}
+	return b.Stat(ctx)
+}
+
+func (b *localBackend) Stat(ctx context.Context) (*ObjectAttrs, error) {
+	return b.Fetch(ctx, ioutil.Discard)
+}
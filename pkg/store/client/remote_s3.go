@@ -0,0 +1,144 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sandromello/wgadmin/pkg/api"
+)
+
+// s3Backend stores the database in an S3 compatible bucket (AWS, Minio, Ceph, ...)
+type s3Backend struct {
+	bucket string
+	object string
+	svc    *s3.S3
+}
+
+func newS3Backend(bucket, object string, c *api.S3Config) (*s3Backend, error) {
+	if c == nil {
+		c = &api.S3Config{}
+	}
+	cfg := aws.NewConfig().
+		WithS3ForcePathStyle(c.ForcePathStyle).
+		WithDisableSSL(c.DisableSSL)
+	if c.Endpoint != "" {
+		cfg = cfg.WithEndpoint(c.Endpoint)
+	}
+	if c.Region != "" {
+		cfg = cfg.WithRegion(c.Region)
+	}
+	if c.AccessKeyID != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, ""))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Backend{bucket: bucket, object: object, svc: s3.New(sess)}, nil
+}
+
+func isS3PreconditionFailed(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.StatusCode() == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+func isS3NotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+func (b *s3Backend) Fetch(ctx context.Context, w io.Writer) (*ObjectAttrs, error) {
+	out, err := b.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.object),
+	})
+	if isS3NotFound(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	if _, err := io.Copy(w, out.Body); err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{
+		Size:       aws.Int64Value(out.ContentLength),
+		Updated:    aws.TimeValue(out.LastModified),
+		Generation: aws.StringValue(out.ETag),
+	}, nil
+}
+
+// Upload checks the ETag of the remote object before writing it and also sends
+// it as a conditional header, not all S3 compatible storages honor conditional writes.
+func (b *s3Backend) Upload(ctx context.Context, r io.Reader, generation string) (*ObjectAttrs, error) {
+	attrs, err := b.Stat(ctx)
+	switch {
+	case err == ErrObjectNotExist:
+		if generation != "" {
+			return nil, ErrConflict
+		}
+	case err != nil:
+		return nil, err
+	case attrs.Generation != generation:
+		return nil, ErrConflict
+	}
+	// PutObject requires a seekable body
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	precondition := func(req *request.Request) {
+		if generation == "" {
+			req.HTTPRequest.Header.Set("If-None-Match", "*")
+			return
+		}
+		req.HTTPRequest.Header.Set("If-Match", generation)
+	}
+	_, err = b.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.object),
+		Body:   bytes.NewReader(data),
+	}, precondition)
+	if isS3PreconditionFailed(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Stat(ctx)
+}
+
+func (b *s3Backend) Stat(ctx context.Context) (*ObjectAttrs, error) {
+	out, err := b.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.object),
+	})
+	if isS3NotFound(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{
+		Size:       aws.Int64Value(out.ContentLength),
+		Updated:    aws.TimeValue(out.LastModified),
+		Generation: aws.StringValue(out.ETag),
+	}, nil
+}
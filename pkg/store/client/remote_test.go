@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sandromello/wgadmin/pkg/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestLocalRemoteBackendSync(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wgadmin-remote-")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	remote, err := NewRemoteBackend(&api.RemoteConfig{
+		Type: api.RemoteBackendLocal,
+		Path: filepath.Join(tmpDir, "remote"),
+	})
+	if err != nil {
+		t.Fatalf("failed creating local backend: %v", err)
+	}
+	dbfile := filepath.Join(tmpDir, "wireguard.db")
+	c, err := New(dbfile, remote, &bolt.Options{})
+	if err != nil {
+		t.Fatalf("failed opening store from empty remote: %v", err)
+	}
+	wgsc := &api.WireguardServerConfig{
+		Metadata:   api.Metadata{UID: "dev"},
+		Address:    "10.100.0.10/24",
+		ListenPort: 51820,
+	}
+	if err := c.WireguardServerConfig().Update(wgsc); err != nil {
+		t.Fatalf("failed updating wireguard server config: %v", err)
+	}
+	if err := c.SyncRemote(); err != nil {
+		t.Fatalf("failed syncing remote: %v", err)
+	}
+	// the local copy must be replaced by the remote one
+	if err := os.Remove(dbfile); err != nil {
+		t.Fatalf("failed removing local database: %v", err)
+	}
+	c, err = New(dbfile, remote, &bolt.Options{})
+	if err != nil {
+		t.Fatalf("failed opening store from remote: %v", err)
+	}
+	defer c.Close()
+	got, err := c.WireguardServerConfig().Get("dev")
+	if err != nil {
+		t.Fatalf("failed retrieving wireguard server config: %v", err)
+	}
+	if diff := cmp.Diff(encode(wgsc), encode(got)); diff != "" {
+		t.Fatalf("unexpected object (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewRemoteBackendErrors(t *testing.T) {
+	for _, c := range []*api.RemoteConfig{
+		nil,
+		{Type: api.RemoteBackendGCS},
+		{Type: api.RemoteBackendS3},
+		{Type: api.RemoteBackendLocal},
+		{Type: "ftp", BucketName: "foo"},
+	} {
+		if _, err := NewRemoteBackend(c); err == nil {
+			t.Fatalf("expected an error for config %#v, but none occurred", c)
+		}
+	}
+}
+
+func TestSyncRemoteConflictAndRetry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wgadmin-remote-")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	remote, err := NewRemoteBackend(&api.RemoteConfig{
+		Type: api.RemoteBackendLocal,
+		Path: filepath.Join(tmpDir, "remote"),
+	})
+	if err != nil {
+		t.Fatalf("failed creating local backend: %v", err)
+	}
+	addServer := func(uid string) MutateFn {
+		return func(c Client) error {
+			return c.WireguardServerConfig().Update(&api.WireguardServerConfig{
+				Metadata: api.Metadata{UID: uid},
+			})
+		}
+	}
+	// two writers fetching the same generation, the last one must conflict
+	c1, err := New(filepath.Join(tmpDir, "c1.db"), remote, &bolt.Options{})
+	if err != nil {
+		t.Fatalf("failed opening store: %v", err)
+	}
+	c2, err := New(filepath.Join(tmpDir, "c2.db"), remote, &bolt.Options{})
+	if err != nil {
+		t.Fatalf("failed opening store: %v", err)
+	}
+	addServer("dev")(c1)
+	addServer("prod")(c2)
+	if err := c1.SyncRemote(); err != nil {
+		t.Fatalf("failed syncing remote: %v", err)
+	}
+	if err := c2.SyncRemote(); err != ErrConflict {
+		t.Fatalf("expected conflict error, got: %v", err)
+	}
+
+	// a concurrent write happens while the mutation is applied,
+	// the mutation must be replayed against the new remote state
+	attempts := 0
+	err = Update(filepath.Join(tmpDir, "c3.db"), remote, &bolt.Options{}, func(c Client) error {
+		attempts++
+		if attempts == 1 {
+			if err := Update(filepath.Join(tmpDir, "c4.db"), remote, &bolt.Options{}, addServer("prod")); err != nil {
+				return err
+			}
+		}
+		return addServer("staging")(c)
+	})
+	if err != nil {
+		t.Fatalf("failed updating store: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	c, err := New(filepath.Join(tmpDir, "c5.db"), remote, &bolt.Options{})
+	if err != nil {
+		t.Fatalf("failed opening store: %v", err)
+	}
+	defer c.Close()
+	wgscList, err := c.WireguardServerConfig().List()
+	if err != nil {
+		t.Fatalf("failed listing wireguard server config: %v", err)
+	}
+	var got []string
+	for _, w := range wgscList {
+		got = append(got, w.UID)
+	}
+	if diff := cmp.Diff([]string{"dev", "prod", "staging"}, got); diff != "" {
+		t.Fatalf("unexpected servers (-want +got):\n%s", diff)
+	}
+}
+
+func TestLocalRemoteBackendStaleLock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wgadmin-remote-")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	b := &localBackend{path: tmpDir, object: "wireguard.db"}
+	lockFile := b.objectPath() + ".lock"
+	// a lock left behind by a crashed process
+	if err := ioutil.WriteFile(lockFile, []byte("1\n"), 0600); err != nil {
+		t.Fatalf("failed writing lock file: %v", err)
+	}
+	staleTime := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(lockFile, staleTime, staleTime); err != nil {
+		t.Fatalf("failed changing lock file times: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := b.Upload(ctx, strings.NewReader("db"), ""); err != nil {
+		t.Fatalf("failed uploading with a stale lock: %v", err)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock file to be removed, got: %v", err)
+	}
+}
+
+func TestUpdateDoesNotShareTheDatabaseFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wgadmin-remote-")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	remote, err := NewRemoteBackend(&api.RemoteConfig{
+		Type: api.RemoteBackendLocal,
+		Path: filepath.Join(tmpDir, "remote"),
+	})
+	if err != nil {
+		t.Fatalf("failed creating local backend: %v", err)
+	}
+	dbfile := filepath.Join(tmpDir, "wireguard.db")
+	opts := &bolt.Options{Timeout: time.Second}
+	addServer := func(uid string) MutateFn {
+		return func(c Client) error {
+			return c.WireguardServerConfig().Update(&api.WireguardServerConfig{
+				Metadata: api.Metadata{UID: uid},
+			})
+		}
+	}
+	// a concurrent writer using the same database file, e.g.: sync-peers and the CLI
+	attempts := 0
+	err = Update(dbfile, remote, opts, func(c Client) error {
+		attempts++
+		if attempts == 1 {
+			if err := Update(dbfile, remote, opts, addServer("prod")); err != nil {
+				return err
+			}
+		}
+		return addServer("staging")(c)
+	})
+	if err != nil {
+		t.Fatalf("failed updating store: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	c, err := New(dbfile, remote, opts)
+	if err != nil {
+		t.Fatalf("failed opening store: %v", err)
+	}
+	defer c.Close()
+	wgscList, err := c.WireguardServerConfig().List()
+	if err != nil {
+		t.Fatalf("failed listing servers: %v", err)
+	}
+	if len(wgscList) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(wgscList))
+	}
+	files, _ := filepath.Glob(dbfile + ".update-*")
+	if len(files) > 0 {
+		t.Fatalf("expected the temporary databases to be removed, found %v", files)
+	}
+}
@@ -1,13 +1,17 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"path"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/sandromello/wgadmin/pkg/api"
 	"github.com/sandromello/wgadmin/pkg/store"
+	bolt "go.etcd.io/bbolt"
 )
 
 // WireguardServerConfig methods to interact with store
@@ -16,11 +20,15 @@ type WireguardServerConfig interface {
 	Update(obj *api.WireguardServerConfig) error
 	List() ([]api.WireguardServerConfig, error)
 	Delete(name string) error
+	DeleteCascade(name, deletedBy string, purge bool) ([]api.Peer, error)
+	UpdateAll(fn func(obj *api.WireguardServerConfig) error) (int, error)
 }
 
 type wireguardServerConfig struct {
-	store  *store.Database
-	prefix string
+	store             *store.Database
+	prefix            string
+	peerPrefix        string
+	archivePeerPrefix string
 }
 
 // List all wireguard server config objects
@@ -68,3 +76,82 @@ func (w *wireguardServerConfig) Update(obj *api.WireguardServerConfig) error {
 func (w *wireguardServerConfig) Delete(name string) error {
 	return w.store.Del(path.Join(w.prefix, name))
 }
+
+// DeleteCascade deletes a wireguard server config and all of its peers in a single
+// transaction. The peers are archived unless purge is set, it returns the removed peers.
+func (w *wireguardServerConfig) DeleteCascade(name, deletedBy string, purge bool) ([]api.Peer, error) {
+	var peers []api.Peer
+	return peers, w.store.Transaction(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(w.store.GetBucket()))
+		if b == nil {
+			return nil
+		}
+		// keys can't be removed while iterating with a cursor
+		var peerNames []string
+		serverPrefix := []byte(path.Join(w.peerPrefix, name) + "/")
+		c := b.Cursor()
+		for k, _ := c.Seek(serverPrefix); k != nil && bytes.HasPrefix(k, serverPrefix); k, _ = c.Next() {
+			peerNames = append(peerNames, strings.TrimPrefix(string(k), w.peerPrefix+"/"))
+		}
+		for _, peerName := range peerNames {
+			if purge {
+				data := b.Get([]byte(path.Join(w.peerPrefix, peerName)))
+				var obj api.Peer
+				if err := json.Unmarshal(data, &obj); err != nil {
+					return err
+				}
+				if err := b.Delete([]byte(path.Join(w.peerPrefix, peerName))); err != nil {
+					return err
+				}
+				peers = append(peers, obj)
+				continue
+			}
+			obj, err := archivePeer(b, w.peerPrefix, w.archivePeerPrefix, peerName, deletedBy)
+			if err != nil {
+				return err
+			}
+			peers = append(peers, *obj)
+		}
+		return b.Delete([]byte(path.Join(w.prefix, name)))
+	})
+}
+
+// UpdateAll applies fn to every wireguard server config and stores the result in
+// a single transaction, nothing is stored if fn fails for any of them.
+// It returns the number of updated objects.
+func (w *wireguardServerConfig) UpdateAll(fn func(obj *api.WireguardServerConfig) error) (int, error) {
+	var updated int
+	return updated, w.store.Transaction(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(w.store.GetBucket()))
+		if b == nil {
+			return nil
+		}
+		// keys can't be modified while iterating with a cursor
+		objs := map[string][]byte{}
+		prefix := []byte(w.prefix + "/")
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			objs[string(k)] = append([]byte(nil), v...)
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		for key, data := range objs {
+			var obj api.WireguardServerConfig
+			if err := json.Unmarshal(data, &obj); err != nil {
+				return err
+			}
+			if err := fn(&obj); err != nil {
+				return fmt.Errorf("%s: %v", obj.UID, err)
+			}
+			obj.UpdatedAt = now
+			jsonData, err := json.Marshal(&obj)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key), jsonData); err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+}
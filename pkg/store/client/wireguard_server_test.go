@@ -2,6 +2,7 @@ package client
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -135,3 +136,91 @@ func TestWireguardServerStoreCRUD(t *testing.T) {
 		t.Fatalf("unexpected object (-want +got):\n%s", diff)
 	}
 }
+
+func TestWireguardServerDeleteCascade(t *testing.T) {
+	c := NewOrDie("", "", &bolt.Options{OpenFile: openTempFile})
+	for _, uid := range []string{"dev", "prod"} {
+		if err := c.WireguardServerConfig().Update(&api.WireguardServerConfig{Metadata: api.Metadata{UID: uid}}); err != nil {
+			t.Fatalf("failed updating wireguard server config: %v", err)
+		}
+	}
+	for _, uid := range []string{"dev/bar", "dev/foo", "prod/foo"} {
+		if err := c.Peer().Update(&api.Peer{Metadata: api.Metadata{UID: uid}}); err != nil {
+			t.Fatalf("failed creating peer: %v", err)
+		}
+	}
+	removed, err := c.WireguardServerConfig().DeleteCascade("dev", "admin@localhost", false)
+	if err != nil {
+		t.Fatalf("failed deleting wireguard server config: %v", err)
+	}
+	if diff := cmp.Diff([]string{"dev/bar", "dev/foo"}, peerUIDs(removed)); diff != "" {
+		t.Fatalf("unexpected removed peers (-want +got):\n%s", diff)
+	}
+	wgsc, err := c.WireguardServerConfig().Get("dev")
+	if err != nil || wgsc != nil {
+		t.Fatalf("expected wireguard server config to be removed, got %v, err=%v", wgsc, err)
+	}
+	peers, _ := c.Peer().List()
+	if diff := cmp.Diff([]string{"prod/foo"}, peerUIDs(peers)); diff != "" {
+		t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+	}
+	archived, _ := c.Peer().ListArchived("dev")
+	if diff := cmp.Diff([]string{"dev/bar", "dev/foo"}, peerUIDs(archived)); diff != "" {
+		t.Fatalf("unexpected archived peers (-want +got):\n%s", diff)
+	}
+
+	// purge must not keep records in the archive
+	if _, err := c.WireguardServerConfig().DeleteCascade("prod", "admin@localhost", true); err != nil {
+		t.Fatalf("failed deleting wireguard server config: %v", err)
+	}
+	archived, _ = c.Peer().ListArchived("prod")
+	if len(archived) != 0 {
+		t.Fatalf("expected no archived peers, got %v", peerUIDs(archived))
+	}
+}
+
+func TestWireguardServerUpdateAll(t *testing.T) {
+	c := NewOrDie("", "", &bolt.Options{OpenFile: openTempFile})
+	for _, uid := range []string{"dev", "prod"} {
+		if err := c.WireguardServerConfig().Update(&api.WireguardServerConfig{
+			Metadata:            api.Metadata{UID: uid},
+			EncryptedPrivateKey: "old",
+		}); err != nil {
+			t.Fatalf("failed creating wireguard server config: %v", err)
+		}
+	}
+	// nothing is stored when any of the objects fails
+	_, err := c.WireguardServerConfig().UpdateAll(func(obj *api.WireguardServerConfig) error {
+		if obj.UID == "prod" {
+			return fmt.Errorf("failed")
+		}
+		obj.EncryptedPrivateKey = "new"
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error updating all wireguard servers, but none occurred")
+	}
+	dev, err := c.WireguardServerConfig().Get("dev")
+	if err != nil {
+		t.Fatalf("failed retrieving wireguard server config: %v", err)
+	}
+	if dev.EncryptedPrivateKey != "old" {
+		t.Fatalf("expected the update to rollback, got %q", dev.EncryptedPrivateKey)
+	}
+	updated, err := c.WireguardServerConfig().UpdateAll(func(obj *api.WireguardServerConfig) error {
+		obj.EncryptedPrivateKey = "new"
+		return nil
+	})
+	if err != nil || updated != 2 {
+		t.Fatalf("failed updating all wireguard servers, updated=%d, err=%v", updated, err)
+	}
+	wgscList, err := c.WireguardServerConfig().List()
+	if err != nil {
+		t.Fatalf("failed listing wireguard server config: %v", err)
+	}
+	for _, w := range wgscList {
+		if w.EncryptedPrivateKey != "new" {
+			t.Fatalf("expected %q to be updated, got %q", w.UID, w.EncryptedPrivateKey)
+		}
+	}
+}
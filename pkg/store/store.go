@@ -18,6 +18,9 @@ type Database struct {
 	db       *bolt.DB
 	bucket   string
 	IsRemote bool
+	// RemoteGeneration is the version of the remote object observed when fetching
+	// the database, it's used as a precondition when syncing it back.
+	RemoteGeneration string
 }
 
 // Close the connection with the database
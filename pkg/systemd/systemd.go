@@ -20,6 +20,7 @@ Description=wgadmin-server: It manages wireguard servers
 Environment="PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 EnvironmentFile=-/etc/default/wgadmin
 ExecStart=/usr/bin/wgadmin sync-servers --config-file $WGADMIN_CONFIG_PATH
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=always
 StartLimitInterval=0
 RestartSec=300
@@ -32,6 +33,7 @@ Description=wgadmin-peer: It manages wireguard peers
 Environment="PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 EnvironmentFile=-/etc/default/wgadmin
 ExecStart=/usr/bin/wgadmin sync-peers --config-file $WGADMIN_CONFIG_PATH
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=always
 StartLimitInterval=0
 RestartSec=300
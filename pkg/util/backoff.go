@@ -0,0 +1,41 @@
+package util
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Backoff doubles the interval for each consecutive failure up to max,
+// the interval is kept when max isn't greater than it.
+func Backoff(interval, max time.Duration, failures int) time.Duration {
+	if max <= interval {
+		return interval
+	}
+	for i := 0; i < failures; i++ {
+		interval *= 2
+		if interval >= max {
+			return max
+		}
+	}
+	return interval
+}
+
+// Jitter randomizes the duration by up to the given fraction of it, e.g.:
+// a fraction of 0.1 returns a duration between 90% and 110% of d.
+func Jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return d + time.Duration((jitterRand.Float64()*2-1)*fraction*float64(d))
+}
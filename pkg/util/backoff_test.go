@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		interval time.Duration
+		max      time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{interval: time.Minute, max: 10 * time.Minute, failures: 0, want: time.Minute},
+		{interval: time.Minute, max: 10 * time.Minute, failures: 1, want: 2 * time.Minute},
+		{interval: time.Minute, max: 10 * time.Minute, failures: 3, want: 8 * time.Minute},
+		{interval: time.Minute, max: 10 * time.Minute, failures: 4, want: 10 * time.Minute},
+		{interval: time.Minute, max: 10 * time.Minute, failures: 100, want: 10 * time.Minute},
+		{interval: time.Minute, max: 0, failures: 3, want: time.Minute},
+	} {
+		if got := Backoff(tt.interval, tt.max, tt.failures); got != tt.want {
+			t.Fatalf("expected backoff %v after %d failure(s), got %v", tt.want, tt.failures, got)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		got := Jitter(time.Minute, 0.1)
+		if got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("expected jitter between 54s and 66s, got %v", got)
+		}
+	}
+	if got := Jitter(time.Minute, 0); got != time.Minute {
+		t.Fatalf("expected no jitter, got %v", got)
+	}
+}
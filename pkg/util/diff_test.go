@@ -0,0 +1,27 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := strings.Split("[Interface]\nAddress = 10.0.0.1/24\nListenPort = 51820\nPostUp = foo", "\n")
+	b := strings.Split("[Interface]\nAddress = 10.0.0.1/24\nListenPort = 51821\nPostUp = foo\nPostDown = bar", "\n")
+	want := []string{
+		" [Interface]",
+		" Address = 10.0.0.1/24",
+		"-ListenPort = 51820",
+		"+ListenPort = 51821",
+		" PostUp = foo",
+		"+PostDown = bar",
+	}
+	if diff := cmp.Diff(want, DiffLines(a, b)); diff != "" {
+		t.Fatalf("unexpected diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"+[Interface]"}, DiffLines(nil, []string{"[Interface]"})); diff != "" {
+		t.Fatalf("unexpected diff (-want +got):\n%s", diff)
+	}
+}
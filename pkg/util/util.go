@@ -1,82 +1,34 @@
 package util
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"math"
-	"net"
+	"strings"
 	"time"
 )
 
-type IPMap struct {
-	values map[string]bool
-	Net    *net.IPNet
-}
-
 type CipherKey struct {
 	Key []byte
 }
 
-func incrementIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
-	}
-}
-
-func (m *IPMap) IsAvailable(ip net.IP) bool {
-	_, ok := m.values[ip.String()]
-	return ok
-}
-
-func (m *IPMap) Del(ipaddr string) {
-	_, ok := m.values[ipaddr]
-	if ok {
-		delete(m.values, ipaddr)
-	}
-}
-
-func (m *IPMap) Pop() *net.IPNet {
-	for ipaddr, _ := range m.values {
-		delete(m.values, ipaddr)
-		_, ipnet, _ := net.ParseCIDR(fmt.Sprintf("%s/32", ipaddr))
-		return ipnet
-	}
-	return nil
-}
-
-// TODO: remove broadcast ip from map
-func NewIPMap(address string) (*IPMap, error) {
-	ipmap := &IPMap{values: make(map[string]bool)}
-	// ipmap := make(IPMap)
-	ipaddr, ipnet, err := net.ParseCIDR(address)
-	if err != nil {
-		return nil, err
-	}
-	ipmap.Net = ipnet
-	for ip := ipaddr.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-		if ip.Equal(ipaddr) || ip[3] == 0 {
-			continue
-		}
-		ipmap.values[ip.String()] = true
-	}
-	return ipmap, nil
-}
+const (
+	// CipherVersionCFB is the legacy format, an AES-CFB message without a version prefix
+	CipherVersionCFB = "v1"
+	// CipherVersionGCM is an authenticated AES-GCM message
+	CipherVersionGCM = "v2"
 
-func pad(src []byte) []byte {
-	padding := aes.BlockSize - len(src)%aes.BlockSize
-	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(src, padtext...)
-}
+	// cipherKeyIDSeparator separates the key id and the version from the
+	// encrypted message, it isn't part of the base64 alphabet.
+	cipherKeyIDSeparator = ":"
+)
 
 func unpad(src []byte) ([]byte, error) {
 	length := len(src)
@@ -113,26 +65,63 @@ func NewAESCipherKey(base64Key string) (*CipherKey, error) {
 	return ck, nil
 }
 
+// EncryptMessage encrypts and authenticates the message using AES-GCM,
+// the result is prefixed with the version of the format.
 func (k *CipherKey) EncryptMessage(rawText string) (string, error) {
 	block, err := aes.NewCipher(k.Key)
 	if err != nil {
 		return "", err
 	}
-
-	msg := pad([]byte(rawText))
-	ciphertext := make([]byte, aes.BlockSize+len(msg))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
 		return "", err
 	}
-
-	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(msg))
-	finalMsg := base64.StdEncoding.EncodeToString(ciphertext)
-	return finalMsg, nil
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(rawText)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, []byte(rawText), nil)
+	return CipherVersionGCM + cipherKeyIDSeparator + base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
+// DecryptMessage decrypts a message encrypted with EncryptMessage,
+// messages without a version are decrypted using the legacy AES-CFB format.
 func (k *CipherKey) DecryptMessage(encryptedText string) (string, error) {
+	switch version := GetCipherVersion(encryptedText); version {
+	case CipherVersionCFB:
+		return k.decryptCFBMessage(encryptedText)
+	case CipherVersionGCM:
+	default:
+		return "", fmt.Errorf("unsupported encryption version %q", version)
+	}
+	block, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	decodedMsg, err := base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(encryptedText, CipherVersionGCM+cipherKeyIDSeparator),
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(decodedMsg) < gcm.NonceSize() {
+		return "", errors.New("encrypted message is too short")
+	}
+	nonce, msg := decodedMsg[:gcm.NonceSize()], decodedMsg[gcm.NonceSize():]
+	rawText, err := gcm.Open(nil, nonce, msg, nil)
+	if err != nil {
+		return "", fmt.Errorf("%v. This could happen when incorrect encryption key is used", err)
+	}
+	return string(rawText), nil
+}
+
+// decryptCFBMessage decrypts messages of the legacy format, it doesn't
+// detect tampering and a wrong key may not produce an error.
+func (k *CipherKey) decryptCFBMessage(encryptedText string) (string, error) {
 	block, err := aes.NewCipher(k.Key)
 	if err != nil {
 		return "", err
@@ -143,7 +132,7 @@ func (k *CipherKey) DecryptMessage(encryptedText string) (string, error) {
 		return "", err
 	}
 
-	if (len(decodedMsg) % aes.BlockSize) != 0 {
+	if len(decodedMsg) == 0 || (len(decodedMsg)%aes.BlockSize) != 0 {
 		return "", errors.New("blocksize must be multipe of decoded message length")
 	}
 
@@ -165,6 +154,43 @@ func (k *CipherKey) String() string {
 	return base64.StdEncoding.EncodeToString(k.Key)
 }
 
+// ID returns a short identifier of the key, it's safe to be stored
+// along with the encrypted messages.
+func (k *CipherKey) ID() string {
+	sum := sha256.Sum256(k.Key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// EncryptMessageWithID encrypts the message prefixing it with the id of the key
+func (k *CipherKey) EncryptMessageWithID(rawText string) (string, error) {
+	encryptedText, err := k.EncryptMessage(rawText)
+	if err != nil {
+		return "", err
+	}
+	return k.ID() + cipherKeyIDSeparator + encryptedText, nil
+}
+
+// SplitCipherKeyID splits the key id from an encrypted message,
+// the id is empty for messages encrypted without one.
+func SplitCipherKeyID(encryptedText string) (keyID, msg string) {
+	parts := strings.SplitN(encryptedText, cipherKeyIDSeparator, 2)
+	// ids are hex encoded, they never start with a version
+	if len(parts) == 1 || strings.HasPrefix(parts[0], "v") {
+		return "", encryptedText
+	}
+	return parts[0], parts[1]
+}
+
+// GetCipherVersion returns the format version of an encrypted message
+func GetCipherVersion(encryptedText string) string {
+	_, msg := SplitCipherKeyID(encryptedText)
+	parts := strings.SplitN(msg, cipherKeyIDSeparator, 2)
+	if len(parts) == 1 {
+		return CipherVersionCFB
+	}
+	return parts[0]
+}
+
 // GenerateRandomString returns a URL-safe, base64 encoded
 // securely generated random string.
 // It will return an error if the system's secure random
@@ -223,3 +249,17 @@ func GetDeltaDuration(startTime, endTime string) string {
 	}
 	return d.String()
 }
+
+// HumanizeBytes formats a size in bytes using binary units, e.g.: 1.5KiB
+func HumanizeBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,237 @@
+package webapp
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// googleJWKSURL is where Google publishes the keys signing its id tokens
+	googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+	jwksDefaultMaxAge      = time.Hour
+	jwksMinRefreshInterval = time.Minute
+	jwksTimeoutInSeconds   = 10
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k *jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.N, "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding modulus of key %q: %v", k.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.E, "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding exponent of key %q: %v", k.Kid, err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// keySet fetches and caches the public keys (JWKS) used to sign id tokens.
+// The keys are kept for the max-age of the response and refreshed
+// when a token is signed by an unknown key.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{
+		url:    url,
+		client: &http.Client{Timeout: jwksTimeoutInSeconds * time.Second},
+	}
+}
+
+func (s *keySet) getKey(kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	key, ok := s.keys[kid]
+	// the provider rotates its keys, refresh them if the key is unknown
+	if now.After(s.expiresAt) || (!ok && now.Sub(s.fetchedAt) > jwksMinRefreshInterval) {
+		if err := s.refresh(now); err != nil {
+			return nil, err
+		}
+		key, ok = s.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", kid)
+	}
+	return key, nil
+}
+
+func (s *keySet) refresh(now time.Time) error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return fmt.Errorf("failed fetching jwks: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed fetching jwks, status=%d", resp.StatusCode)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed decoding jwks: %v", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pubKey, err := k.rsaPublicKey()
+		if err != nil {
+			return err
+		}
+		keys[k.Kid] = pubKey
+	}
+	s.keys = keys
+	s.fetchedAt = now
+	s.expiresAt = now.Add(parseMaxAge(resp.Header.Get("Cache-Control")))
+	return nil
+}
+
+// parseMaxAge returns the max-age directive of a Cache-Control header
+func parseMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil || seconds <= 0 {
+			break
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return jwksDefaultMaxAge
+}
+
+// idTokenVerifier verifies the signature and the claims of id tokens
+type idTokenVerifier struct {
+	keys     *keySet
+	audience string
+	issuers  []string
+}
+
+func newGoogleIDTokenVerifier(jwksURL, clientID string) *idTokenVerifier {
+	if jwksURL == "" {
+		jwksURL = googleJWKSURL
+	}
+	return &idTokenVerifier{
+		keys:     newKeySet(jwksURL),
+		audience: clientID,
+		issuers:  googleIssuers,
+	}
+}
+
+// Verify parses the id token returning its claims, it fails if the signature
+// doesn't match any of the provider keys, the token is expired or it wasn't
+// issued to the client id.
+func (v *idTokenVerifier) Verify(idToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.getKey(kid)
+	}); err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("token doesn't have an expiration")
+	}
+	if !v.verifyAudience(claims) {
+		return nil, fmt.Errorf("token audience %v doesn't match the client id", claims["aud"])
+	}
+	for _, iss := range v.issuers {
+		if claims.VerifyIssuer(iss, true) {
+			return claims, nil
+		}
+	}
+	return nil, fmt.Errorf("token issuer %v isn't allowed", claims["iss"])
+}
+
+// verifyAudience checks the aud claim, it could be a string or a list of strings
+func (v *idTokenVerifier) verifyAudience(claims jwt.MapClaims) bool {
+	if v.audience == "" {
+		return false
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == v.audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, _ := a.(string); s == v.audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newUserInfo maps the claims of a verified id token to a *UserInfo,
+// the email and groups are read from the given claims.
+func newUserInfo(claims jwt.MapClaims, emailClaim, groupsClaim string) (*UserInfo, error) {
+	// the audience could be a list which doesn't fit in the standard claims
+	standard := jwt.MapClaims{}
+	for key, val := range claims {
+		if key != "aud" {
+			standard[key] = val
+		}
+	}
+	data, err := json.Marshal(standard)
+	if err != nil {
+		return nil, err
+	}
+	u := &UserInfo{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("failed decoding claims: %v", err)
+	}
+	if emailClaim != "" {
+		u.Email, _ = claims[emailClaim].(string)
+	}
+	if u.Email == "" {
+		return nil, fmt.Errorf("claim %q not found in token", emailClaim)
+	}
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		u.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				u.Groups = append(u.Groups, s)
+			}
+		}
+	}
+	return u, nil
+}
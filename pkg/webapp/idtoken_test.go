@@ -0,0 +1,124 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func jwksHandler(t *testing.T, keys map[string]*rsa.PublicKey) http.HandlerFunc {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	for kid, k := range keys {
+		jwks.Keys = append(jwks.Keys, jsonWebKey{
+			Kid: kid,
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(&jwks); err != nil {
+			t.Errorf("failed encoding jwks: %v", err)
+		}
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed signing token: %v", err)
+	}
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating rsa key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating rsa key: %v", err)
+	}
+	srv := httptest.NewServer(jwksHandler(t, map[string]*rsa.PublicKey{"key01": &privKey.PublicKey}))
+	defer srv.Close()
+	v := newGoogleIDTokenVerifier(srv.URL, "client-id")
+
+	newClaims := func(mutate func(u *UserInfo)) *UserInfo {
+		u := &UserInfo{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "client-id",
+				Issuer:    "https://accounts.google.com",
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+			Email:         "foo@acme.org",
+			EmailVerified: true,
+		}
+		if mutate != nil {
+			mutate(u)
+		}
+		return u
+	}
+	for _, tt := range []struct {
+		name    string
+		token   string
+		isValid bool
+	}{
+		{
+			name:    "valid token",
+			token:   signToken(t, jwt.SigningMethodRS256, "key01", privKey, newClaims(nil)),
+			isValid: true,
+		},
+		{
+			name:  "wrong audience",
+			token: signToken(t, jwt.SigningMethodRS256, "key01", privKey, newClaims(func(u *UserInfo) { u.Audience = "other" })),
+		},
+		{
+			name:  "wrong issuer",
+			token: signToken(t, jwt.SigningMethodRS256, "key01", privKey, newClaims(func(u *UserInfo) { u.Issuer = "https://evil.org" })),
+		},
+		{
+			name:  "expired token",
+			token: signToken(t, jwt.SigningMethodRS256, "key01", privKey, newClaims(func(u *UserInfo) { u.ExpiresAt = time.Now().Add(-time.Minute).Unix() })),
+		},
+		{
+			name:  "missing expiration",
+			token: signToken(t, jwt.SigningMethodRS256, "key01", privKey, newClaims(func(u *UserInfo) { u.ExpiresAt = 0 })),
+		},
+		{
+			name:  "forged signature",
+			token: signToken(t, jwt.SigningMethodRS256, "key01", otherKey, newClaims(nil)),
+		},
+		{
+			name:  "unknown key",
+			token: signToken(t, jwt.SigningMethodRS256, "key02", otherKey, newClaims(nil)),
+		},
+		{
+			name:  "symmetric signing method",
+			token: signToken(t, jwt.SigningMethodHS256, "key01", []byte(``), newClaims(nil)),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := v.Verify(tt.token)
+			if tt.isValid && err != nil {
+				t.Fatalf("failed verifying token: %v", err)
+			}
+			if !tt.isValid && err == nil {
+				t.Fatalf("expected an error verifying token, got claims: %#v", u)
+			}
+		})
+	}
+}
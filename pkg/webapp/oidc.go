@@ -0,0 +1,183 @@
+package webapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	"github.com/sandromello/wgadmin/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcDiscoveryPath     = "/.well-known/openid-configuration"
+	oidcTimeoutInSeconds  = 10
+	oidcStateSessionKey   = "oidc_state"
+	oidcNonceSessionKey   = "oidc_nonce"
+	oidcRandomValueLength = 32
+)
+
+// oidcDiscovery is the subset of the provider metadata used by the webapp
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider authenticates users with the authorization code flow
+// of an OpenID Connect provider (Keycloak, Dex, ...)
+type oidcProvider struct {
+	config        *oauth2.Config
+	verifier      *idTokenVerifier
+	emailClaim    string
+	groupsClaim   string
+	allowedGroups []string
+}
+
+func newOIDCProvider(c *api.OIDCConfig) (*oidcProvider, error) {
+	if c.IssuerURL == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return nil, fmt.Errorf("issuerURL, clientID and redirectURL attributes are required")
+	}
+	issuer := strings.TrimSuffix(c.IssuerURL, "/")
+	client := &http.Client{Timeout: oidcTimeoutInSeconds * time.Second}
+	resp, err := client.Get(issuer + oidcDiscoveryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching provider metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed fetching provider metadata, status=%d", resp.StatusCode)
+	}
+	var d oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed decoding provider metadata: %v", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer %q doesn't match the discovered one %q", c.IssuerURL, d.Issuer)
+	}
+	return &oidcProvider{
+		config: &oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  c.RedirectURL,
+			Scopes:       c.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  d.AuthorizationEndpoint,
+				TokenURL: d.TokenEndpoint,
+			},
+		},
+		verifier: &idTokenVerifier{
+			keys:     newKeySet(d.JWKSURI),
+			audience: c.ClientID,
+			issuers:  []string{d.Issuer},
+		},
+		emailClaim:    c.EmailClaim,
+		groupsClaim:   c.GroupsClaim,
+		allowedGroups: c.AllowedGroups,
+	}, nil
+}
+
+func (p *oidcProvider) isAllowedGroup(groups []string) bool {
+	if len(p.allowedGroups) == 0 {
+		return true
+	}
+	for _, allowed := range p.allowedGroups {
+		for _, g := range groups {
+			if g == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OIDCLogin redirects the user to the authorization endpoint of the provider
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		h.httpError(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	session, err := h.store.Get(r, "wgadmin")
+	if err != nil {
+		h.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state, err := util.GenerateRandomString(oidcRandomValueLength)
+	if err != nil {
+		h.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := util.GenerateRandomString(oidcRandomValueLength)
+	if err != nil {
+		h.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.Values[oidcStateSessionKey] = state
+	session.Values[oidcNonceSessionKey] = nonce
+	if err := session.Save(r, w); err != nil {
+		h.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, h.oidc.config.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)), http.StatusFound)
+}
+
+// OIDCCallback exchanges the authorization code by an id token and signs in the user
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		h.httpError(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	session, err := h.store.Get(r, "wgadmin")
+	if err != nil {
+		h.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state, _ := session.Values[oidcStateSessionKey].(string)
+	nonce, _ := session.Values[oidcNonceSessionKey].(string)
+	// the state and nonce are valid only once
+	delete(session.Values, oidcStateSessionKey)
+	delete(session.Values, oidcNonceSessionKey)
+
+	query := r.URL.Query()
+	if errMsg := query.Get("error"); errMsg != "" {
+		msg := fmt.Sprintf("Authentication failed: %s %s", errMsg, query.Get("error_description"))
+		h.httpError(w, msg, http.StatusUnauthorized)
+		return
+	}
+	if state == "" || query.Get("state") != state {
+		h.httpError(w, "Invalid state, try to sign in again!", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeoutInSeconds*time.Second)
+	defer cancel()
+	token, err := h.oidc.config.Exchange(ctx, query.Get("code"))
+	if err != nil {
+		log.Warnf("failed exchanging authorization code: %v", err)
+		h.httpError(w, "Failed exchanging the authorization code", http.StatusUnauthorized)
+		return
+	}
+	idToken, _ := token.Extra("id_token").(string)
+	claims, err := h.oidc.verifier.Verify(idToken)
+	if err != nil {
+		log.Warnf("failed verifying id token: %v", err)
+		h.httpError(w, "Invalid id token", http.StatusUnauthorized)
+		return
+	}
+	if claims["nonce"] != nonce {
+		h.httpError(w, "Invalid nonce, try to sign in again!", http.StatusUnauthorized)
+		return
+	}
+	u, err := newUserInfo(claims, h.oidc.emailClaim, h.oidc.groupsClaim)
+	if err != nil {
+		h.httpError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.signin(w, r, session, u)
+}
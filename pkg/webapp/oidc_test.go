@@ -0,0 +1,160 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/securecookie"
+	"github.com/sandromello/wgadmin/pkg/api"
+)
+
+// mockIdP is a minimal OpenID Connect provider issuing id tokens
+// with the claims of the test case
+type mockIdP struct {
+	*httptest.Server
+	claims jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating rsa key: %v", err)
+	}
+	idp := &mockIdP{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&oidcDiscovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/auth",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", jwksHandler(t, map[string]*rsa.PublicKey{"key01": &privKey.PublicKey}))
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "auth-code" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     signToken(t, jwt.SigningMethodRS256, "key01", privKey, idp.claims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+	c := &api.WebApp{
+		AllowedDomains: []string{"acme.org"},
+		PageConfig:     &api.PageConfig{TemplatePath: "../../web/templates"},
+		OIDC: &api.OIDCConfig{
+			IssuerURL:     idp.URL,
+			ClientID:      "wgadmin",
+			ClientSecret:  "secret",
+			RedirectURL:   "https://wgadmin.acme.org/oidc/callback",
+			EmailClaim:    "mail",
+			AllowedGroups: []string{"vpn"},
+		},
+	}
+	c.SetDefaults()
+	h, err := NewHandler([][]byte{securecookie.GenerateRandomKey(32)}, c, nil)
+	if err != nil {
+		t.Fatalf("failed creating handler: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		claims      func(nonce string) jwt.MapClaims
+		state       string
+		expCode     int
+		expLocation string
+	}{
+		{
+			name: "valid user",
+			claims: func(nonce string) jwt.MapClaims {
+				return jwt.MapClaims{"mail": "foo@acme.org", "email_verified": true, "groups": []string{"dev", "vpn"}, "nonce": nonce}
+			},
+			expCode:     http.StatusSeeOther,
+			expLocation: "/",
+		},
+		{
+			name: "user without allowed group",
+			claims: func(nonce string) jwt.MapClaims {
+				return jwt.MapClaims{"mail": "foo@acme.org", "email_verified": true, "groups": []string{"dev"}, "nonce": nonce}
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name: "user from another domain",
+			claims: func(nonce string) jwt.MapClaims {
+				return jwt.MapClaims{"mail": "foo@evil.org", "email_verified": true, "groups": "vpn", "nonce": nonce}
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong nonce",
+			claims: func(nonce string) jwt.MapClaims {
+				return jwt.MapClaims{"mail": "foo@acme.org", "email_verified": true, "groups": "vpn", "nonce": "replayed"}
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong state",
+			claims: func(nonce string) jwt.MapClaims {
+				return jwt.MapClaims{"mail": "foo@acme.org", "email_verified": true, "groups": "vpn", "nonce": nonce}
+			},
+			state:   "forged",
+			expCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.OIDCLogin(rec, httptest.NewRequest("GET", "/oidc/login", nil))
+			if rec.Code != http.StatusFound {
+				t.Fatalf("expected redirect to the provider, got status %d", rec.Code)
+			}
+			authURL, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("failed parsing authorization url: %v", err)
+			}
+			query := authURL.Query()
+			if query.Get("client_id") != "wgadmin" || query.Get("scope") != "openid email profile" {
+				t.Fatalf("unexpected authorization url: %v", authURL)
+			}
+			idp.claims = tt.claims(query.Get("nonce"))
+			idp.claims["iss"] = idp.URL
+			idp.claims["aud"] = []string{"wgadmin"}
+			idp.claims["exp"] = time.Now().Add(time.Hour).Unix()
+
+			state := query.Get("state")
+			if tt.state != "" {
+				state = tt.state
+			}
+			req := httptest.NewRequest("GET", "/oidc/callback?code=auth-code&state="+state, nil)
+			for _, cookie := range rec.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			rec = httptest.NewRecorder()
+			h.OIDCCallback(rec, req)
+			if rec.Code != tt.expCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expCode, rec.Code, rec.Body.String())
+			}
+			if location := rec.Header().Get("Location"); location != tt.expLocation {
+				t.Fatalf("expected location %q, got %q", tt.expLocation, location)
+			}
+		})
+	}
+}
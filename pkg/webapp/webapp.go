@@ -3,6 +3,7 @@ package webapp
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -45,14 +46,15 @@ const (
 type UserInfo struct {
 	jwt.StandardClaims
 
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"email_verified"`
-	Name          string `json:"name"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	GSuiteDomain  string `json:"hd"`
-	Locale        string `json:"locale"`
-	Picture       string `json:"picture"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	GSuiteDomain  string   `json:"hd"`
+	Locale        string   `json:"locale"`
+	Picture       string   `json:"picture"`
+	Groups        []string `json:"groups,omitempty"`
 }
 
 // ToJSON converts a *UserInfo to json
@@ -79,22 +81,37 @@ type Handler struct {
 	store          *sessions.CookieStore
 	pageConfig     *api.PageConfig
 	allowedDomains []string
+	remote         storeclient.RemoteBackend
+	verifier       *idTokenVerifier
+	oidc           *oidcProvider
 }
 
-// NewHandler creates a new handler
-func NewHandler(sessionKey []byte, pconfig *api.PageConfig, allowedDomains []string) *Handler {
-	if pconfig == nil {
-		log.Fatal("page config attribute is nil")
+// NewHandler creates a new handler, the users are authenticated with an
+// OpenID Connect provider if it's configured, otherwise with Google Sign-In.
+// The key pairs are passed to the cookie store, the first pair signs new sessions.
+func NewHandler(keyPairs [][]byte, c *api.WebApp, remote storeclient.RemoteBackend) (*Handler, error) {
+	if c.PageConfig == nil {
+		return nil, errors.New("page config attribute is nil")
 	}
 	h := &Handler{
-		store:          sessions.NewCookieStore(sessionKey),
-		pageConfig:     pconfig,
-		allowedDomains: allowedDomains,
+		store:          sessions.NewCookieStore(keyPairs...),
+		pageConfig:     c.PageConfig,
+		allowedDomains: c.AllowedDomains,
+		remote:         remote,
+	}
+	if c.OIDC != nil {
+		provider, err := newOIDCProvider(c.OIDC)
+		if err != nil {
+			return nil, fmt.Errorf("failed initializing oidc provider: %v", err)
+		}
+		h.oidc = provider
+	} else {
+		h.verifier = newGoogleIDTokenVerifier(c.PageConfig.GoogleJWKSURL, c.PageConfig.GoogleClientID)
 	}
 
 	h.RenderTemplates()
 	h.store.MaxAge(sessionMaxAgeInSeconds)
-	return h
+	return h, nil
 }
 
 func (h *Handler) isAllowedDomain(email string) (bool, string) {
@@ -126,7 +143,9 @@ func (h *Handler) RenderTemplates() {
 	indexPage := filepath.Join("", h.pageConfig.TemplatePath, indexPageName)
 	loginPage := filepath.Join("", h.pageConfig.TemplatePath, loginPageName)
 	errorPage := filepath.Join("", h.pageConfig.TemplatePath, errorPageName)
-	tmpl, err := template.New("").ParseFiles(indexPage, loginPage, errorPage)
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"humanizeBytes": util.HumanizeBytes,
+	}).ParseFiles(indexPage, loginPage, errorPage)
 	if err != nil {
 		log.Fatalf("failed rendering templates: %v", err)
 	}
@@ -154,32 +173,23 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		idToken := r.FormValue("id_token")
-		token, err := jwt.ParseWithClaims(idToken, &UserInfo{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(``), nil
-		})
-		if u, ok := token.Claims.(*UserInfo); ok {
-			if ok, d := h.isAllowedDomain(u.Email); !ok {
-				msg := fmt.Sprintf("Users from domain %s aren't allowed to signin!", d)
-				h.httpError(w, msg, http.StatusUnauthorized)
-				return
-			}
-			if !u.EmailVerified {
-				h.httpError(w, "Email not verified", http.StatusUnauthorized)
-				return
-			}
-			session.Values["userinfo"] = u.ToJSON()
-			expireAt := time.Unix(u.ExpiresAt, 0).Sub(time.Now().UTC())
-			log.Infof("user %v signed in, expires in %v minutes", u.Email, int(expireAt.Minutes()))
-			session.Options.MaxAge = int(expireAt.Seconds())
-			if err := session.Save(r, w); err != nil {
-				h.httpError(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-		} else {
-			h.httpError(w, err.Error(), http.StatusInternalServerError)
+		// the id token is posted by the Google Sign-In
+		if h.verifier == nil {
+			h.httpError(w, "Method Not Implemented", http.StatusNotImplemented)
+			return
+		}
+		claims, err := h.verifier.Verify(r.FormValue("id_token"))
+		if err != nil {
+			log.Warnf("failed verifying id token: %v", err)
+			h.httpError(w, "Invalid id token", http.StatusUnauthorized)
+			return
 		}
+		u, err := newUserInfo(claims, "email", "")
+		if err != nil {
+			h.httpError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.signin(w, r, session, u)
 	case "GET":
 		u, err := h.getSessionUser(r)
 		if err != nil {
@@ -192,7 +202,7 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 		}
 		// TODO: refactor
 		configPath := filepath.Join(os.Getenv("$HOME/.wgapp/"), store.DBFileName)
-		client, err := storeclient.New(configPath, &bolt.Options{OpenFile: storeclient.FetchFromGCS})
+		client, err := storeclient.New(configPath, h.remote, &bolt.Options{})
 		if err != nil {
 			h.httpError(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -224,6 +234,32 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// signin stores the user in the session if it's allowed to sign in
+func (h *Handler) signin(w http.ResponseWriter, r *http.Request, session *sessions.Session, u *UserInfo) {
+	if ok, d := h.isAllowedDomain(u.Email); !ok {
+		msg := fmt.Sprintf("Users from domain %s aren't allowed to signin!", d)
+		h.httpError(w, msg, http.StatusUnauthorized)
+		return
+	}
+	if !u.EmailVerified {
+		h.httpError(w, "Email not verified", http.StatusUnauthorized)
+		return
+	}
+	if h.oidc != nil && !h.oidc.isAllowedGroup(u.Groups) {
+		h.httpError(w, "User isn't member of an allowed group!", http.StatusUnauthorized)
+		return
+	}
+	session.Values["userinfo"] = u.ToJSON()
+	expireAt := time.Unix(u.ExpiresAt, 0).Sub(time.Now().UTC())
+	log.Infof("user %v signed in, expires in %v minutes", u.Email, int(expireAt.Minutes()))
+	session.Options.MaxAge = int(expireAt.Seconds())
+	if err := session.Save(r, w); err != nil {
+		h.httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 // Signin the webapp login page
 func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 	if os.Getenv("ENV") != "production" {
@@ -231,6 +267,7 @@ func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 	}
 	if err := h.tmpl.ExecuteTemplate(w, loginPageName, map[string]interface{}{
 		"PageConfig": h.pageConfig,
+		"OIDC":       h.oidc != nil,
 	}); err != nil {
 		log.Errorf("failed executing template: %v", err)
 	}
@@ -269,164 +306,152 @@ func (h *Handler) Peers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	configPath := filepath.Join(os.Getenv("$HOME/.wgapp/"), store.DBFileName)
-	client, err := storeclient.New(configPath, &bolt.Options{OpenFile: storeclient.FetchFromGCS})
-	if err != nil {
-		msg := fmt.Sprintf("Error: failed creating client config: %v", err)
-		h.httpError(w, msg, http.StatusInternalServerError)
-		return
-	}
-	defer client.Close()
 
 	switch r.Method {
 	case "POST":
 		// expect <server>/<peer>
 		peerUID := r.FormValue("peer_uid")
-		peer, err := client.Peer().Get(peerUID)
-		if err != nil {
-			msg := fmt.Sprintf("Error: failed fetching peer %v: %v", peerUID, err)
-			h.httpError(w, msg, http.StatusInternalServerError)
-			return
-		}
-		if peer == nil {
-			h.httpError(w, "Peer not found!", http.StatusNotFound)
-			return
-		}
-		if !u.EmailVerified {
-			h.httpError(w, "E-mail not verified!", http.StatusUnauthorized)
-			return
-		}
-		log.Infof("got peer=%v, found=%v", peerUID, peer.UID)
-		parts := strings.Split(peer.UID, "/")
-		if len(parts) == 2 && parts[1] != u.Email {
-			h.httpError(w, "Peer doesn't match with email", http.StatusUnauthorized)
-			return
-		}
-		if peer.GetStatus() == api.PeerBlocked {
-			msg := fmt.Sprintf("Peer %s is blocked!", peer.UID)
-			h.httpError(w, msg, http.StatusForbidden)
-			return
-		}
-		if peer.Spec.PersistentPublicKey != nil {
-			msg := fmt.Sprintf("Peer %s has a persistent public key which couldn't be renewed!", peer.UID)
-			h.httpError(w, msg, http.StatusForbidden)
-			return
-		}
-		// Reset Peer
-		randomString, err := util.GenerateRandomString(50)
-		if err != nil {
-			h.httpError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		var peer *api.Peer
+		err := storeclient.Update(configPath, h.remote, &bolt.Options{}, func(client storeclient.Client) error {
+			var err error
+			peer, err = client.Peer().Get(peerUID)
+			if err != nil {
+				msg := fmt.Sprintf("Error: failed fetching peer %v: %v", peerUID, err)
+				return newStatusError(msg, http.StatusInternalServerError)
+			}
+			if peer == nil {
+				return newStatusError("Peer not found!", http.StatusNotFound)
+			}
+			if !u.EmailVerified {
+				return newStatusError("E-mail not verified!", http.StatusUnauthorized)
+			}
+			log.Infof("got peer=%v, found=%v", peerUID, peer.UID)
+			parts := strings.Split(peer.UID, "/")
+			if len(parts) == 2 && parts[1] != u.Email {
+				return newStatusError("Peer doesn't match with email", http.StatusUnauthorized)
+			}
+			if peer.GetStatus() == api.PeerBlocked {
+				msg := fmt.Sprintf("Peer %s is blocked!", peer.UID)
+				return newStatusError(msg, http.StatusForbidden)
+			}
+			if peer.Spec.PersistentPublicKey != nil {
+				msg := fmt.Sprintf("Peer %s has a persistent public key which couldn't be renewed!", peer.UID)
+				return newStatusError(msg, http.StatusForbidden)
+			}
+			// Reset Peer
+			randomString, err := util.GenerateRandomString(50)
+			if err != nil {
+				return err
+			}
 
-		peer.CreatedAt = time.Now().UTC().Format(time.RFC3339)
-		peer.Status = api.PeerStatus{
-			SecretValue: fmt.Sprintf("%s.conf", randomString),
-			PublicKey:   nil,
-		}
-		if err := client.Peer().Update(peer); err != nil {
-			msg := fmt.Sprintf("Error: failed updating peer %v: %v", peer.UID, err)
-			h.httpError(w, msg, http.StatusInternalServerError)
-			return
-		}
-		if err := client.SyncRemote(); err != nil {
-			msg := fmt.Sprintf("Error: failed syncing with GCS: %v", err)
-			h.httpError(w, msg, http.StatusInternalServerError)
+			peer.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+			peer.Status = api.PeerStatus{
+				SecretValue: fmt.Sprintf("%s.conf", randomString),
+				PublicKey:   nil,
+				RenewConfig: peer.Status.RenewConfig,
+				Stats:       peer.Status.Stats,
+			}
+			if err := client.Peer().Update(peer); err != nil {
+				msg := fmt.Sprintf("Error: failed updating peer %v: %v", peer.UID, err)
+				return newStatusError(msg, http.StatusInternalServerError)
+			}
+			return nil
+		})
+		if err != nil {
+			h.storeError(w, err)
 			return
 		}
 		redirectURL := fmt.Sprintf("/peers/%s?vpn=%s", peer.Status.SecretValue, peer.GetServer())
 		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 	case "GET":
-		peerList, err := client.Peer().List()
-		if err != nil {
-			msg := fmt.Sprintf("Error: failed listing peers: %v", err)
-			h.httpError(w, msg, http.StatusInternalServerError)
-			return
-		}
-		var peer *api.Peer
 		secretParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
 		if secretParts[1] == "" {
 			h.httpError(w, "Not Found", http.StatusNotFound)
 			return
 		}
-		for _, p := range peerList {
-			parts := strings.Split(p.UID, "/")
-			if len(parts) != 2 || u.Email != parts[1] {
-				continue
+		vpn := r.URL.Query().Get("vpn")
+		var data []byte
+		err := storeclient.Update(configPath, h.remote, &bolt.Options{}, func(client storeclient.Client) error {
+			peerList, err := client.Peer().List()
+			if err != nil {
+				msg := fmt.Sprintf("Error: failed listing peers: %v", err)
+				return newStatusError(msg, http.StatusInternalServerError)
 			}
-			if p.Status.SecretValue != "" && p.Status.SecretValue == secretParts[1] {
-				peer = &p
-				break
+			var peer *api.Peer
+			for _, p := range peerList {
+				parts := strings.Split(p.UID, "/")
+				if len(parts) != 2 || u.Email != parts[1] {
+					continue
+				}
+				if p.Status.SecretValue != "" && p.Status.SecretValue == secretParts[1] {
+					peer = &p
+					break
+				}
+			}
+			if peer == nil {
+				return newStatusError("Error: peer not found for this token.", http.StatusNotFound)
+			}
+			if peer.GetStatus() == api.PeerBlocked {
+				return newStatusError("Error: peer blocked, contact the administrator!", http.StatusBadRequest)
+			}
+			updAt, err := time.Parse(time.RFC3339, peer.UpdatedAt)
+			if err != nil {
+				return newStatusError("Error: failed parsing updated time for peer!", http.StatusInternalServerError)
+			}
+			if updAt.Add(time.Minute * 15).Before(time.Now().UTC()) {
+				msg := fmt.Sprintf("Error: secret has expired, updated at: %v!", peer.UpdatedAt)
+				return newStatusError(msg, http.StatusBadRequest)
 			}
-		}
-		if peer == nil {
-			h.httpError(w, "Error: peer not found for this token.", http.StatusNotFound)
-			return
-		}
-		if peer.GetStatus() == api.PeerBlocked {
-			h.httpError(w, "Error: peer blocked, contact the administrator!", http.StatusBadRequest)
-			return
-		}
-		updAt, err := time.Parse(time.RFC3339, peer.UpdatedAt)
-		if err != nil {
-			h.httpError(w, "Error: failed parsing updated time for peer!", http.StatusInternalServerError)
-			return
-		}
-		if updAt.Add(time.Minute * 15).Before(time.Now().UTC()) {
-			msg := fmt.Sprintf("Error: secret has expired, updated at: %v!", peer.UpdatedAt)
-			h.httpError(w, msg, http.StatusBadRequest)
-			return
-		}
 
-		clientPrivkey, err := api.GeneratePrivateKey()
-		if err != nil {
-			h.httpError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+			clientPrivkey, err := api.GeneratePrivateKey()
+			if err != nil {
+				return err
+			}
+			now := time.Now().UTC()
 
-		vpn := r.URL.Query().Get("vpn")
-		wgsc, err := client.WireguardServerConfig().Get(vpn)
-		if wgsc == nil && err == nil {
-			msg := fmt.Sprintf("Error: the wireguard server %q doesn't exists", vpn)
-			h.httpError(w, msg, http.StatusBadRequest)
-			return
-		}
-		if err != nil {
-			msg := fmt.Sprintf("Error: failed retrieving wireguard server config object: %v", err)
-			h.httpError(w, msg, http.StatusInternalServerError)
-			return
-		}
-		peerMTU := api.PeerDefaultMTU
-		if peer.Spec.ClientMTU != "" {
-			peerMTU = peer.Spec.ClientMTU
-		}
-		data, err := api.ParseWireguardClientConfigTemplate(map[string]interface{}{
-			"PrivateKey": clientPrivkey,
-			"PublicKey":  wgsc.PublicKey.String(),
-			"Address":    peer.Spec.AllowedIPs,
-			"DNS":        "1.1.1.1, 8.8.8.8",
-			"Endpoint":   wgsc.PublicEndpoint,
-			"AllowedIPs": "0.0.0.0/0, ::/0",
-			"MTU":        peerMTU,
+			wgsc, err := client.WireguardServerConfig().Get(vpn)
+			if wgsc == nil && err == nil {
+				msg := fmt.Sprintf("Error: the wireguard server %q doesn't exists", vpn)
+				return newStatusError(msg, http.StatusBadRequest)
+			}
+			if err != nil {
+				msg := fmt.Sprintf("Error: failed retrieving wireguard server config object: %v", err)
+				return newStatusError(msg, http.StatusInternalServerError)
+			}
+			peerMTU := api.PeerDefaultMTU
+			if peer.Spec.ClientMTU != "" {
+				peerMTU = peer.Spec.ClientMTU
+			}
+			data, err = api.ParseWireguardClientConfigTemplate(map[string]interface{}{
+				"PrivateKey": clientPrivkey,
+				"PublicKey":  wgsc.GetActivePublicKey(now).String(),
+				"Address":    peer.Spec.AllowedIPs,
+				"DNS":        "1.1.1.1, 8.8.8.8",
+				"Endpoint":   wgsc.PublicEndpoint,
+				"AllowedIPs": peer.GetClientRoutes(wgsc),
+				"MTU":        peerMTU,
+			})
+			if err != nil {
+				return err
+			}
+			pubkey := clientPrivkey.PublicKey()
+			peer.Status = api.PeerStatus{
+				// it's important to let the client to download the
+				// configuration only once for security concerns.
+				SecretValue: "",
+				PublicKey:   &pubkey,
+				// the config must be renewed again after a pending key rotation
+				RenewConfig: wgsc.KeyRotation != nil && !wgsc.KeyRotation.IsSwitched(now),
+				Stats:       peer.Status.Stats,
+			}
+			if err := client.Peer().Update(peer); err != nil {
+				msg := fmt.Sprintf("Error: failed updating peer: %v", err)
+				return newStatusError(msg, http.StatusInternalServerError)
+			}
+			return nil
 		})
 		if err != nil {
-			h.httpError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		pubkey := clientPrivkey.PublicKey()
-		peer.Status = api.PeerStatus{
-			// it's important to let the client to download the
-			// configuration only once for security concerns.
-			SecretValue: "",
-			PublicKey:   &pubkey,
-		}
-		if err := client.Peer().Update(peer); err != nil {
-			msg := fmt.Sprintf("Error: failed updating peer: %v", err)
-			h.httpError(w, msg, http.StatusInternalServerError)
-			return
-		}
-		if err := client.SyncRemote(); err != nil {
-			msg := fmt.Sprintf("Error: failed syncing with GCS: %v", err)
-			h.httpError(w, msg, http.StatusInternalServerError)
+			h.storeError(w, err)
 			return
 		}
 		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -442,6 +467,28 @@ func (h *Handler) Peers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// statusError is returned from store mutations to reply with a specific status code
+type statusError struct {
+	msg  string
+	code int
+}
+
+func newStatusError(msg string, code int) error {
+	return &statusError{msg: msg, code: code}
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// storeError replies an error which happened when interacting with the store
+func (h *Handler) storeError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*statusError); ok {
+		h.httpError(w, e.msg, e.code)
+		return
+	}
+	msg := fmt.Sprintf("Error: failed syncing with remote: %v", err)
+	h.httpError(w, msg, http.StatusInternalServerError)
+}
+
 func (h *Handler) httpError(w http.ResponseWriter, msg string, code int) {
 	if os.Getenv("ENV") != "production" {
 		h.RenderTemplates()
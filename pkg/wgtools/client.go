@@ -0,0 +1,108 @@
+package wgtools
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Backend is the implementation used to manage wireguard devices
+type Backend string
+
+const (
+	// BackendAuto uses netlink and fallbacks to the wg tool
+	// if the device isn't reachable with netlink
+	BackendAuto Backend = "auto"
+	// BackendNetlink uses the wireguard netlink interface (wgctrl)
+	BackendNetlink Backend = "netlink"
+	// BackendExec executes the wg tool
+	BackendExec Backend = "exec"
+)
+
+// Peer is the runtime state of a peer in a wireguard device
+type Peer struct {
+	PublicKey  string
+	AllowedIPs []string
+	// Endpoint is the last observed address of the peer
+	Endpoint string
+	// LastHandshake is zero if the peer never connected
+	LastHandshake time.Time
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// PeerConfig adds, updates or removes a peer from a wireguard device
+type PeerConfig struct {
+	PublicKey  string
+	AllowedIPs []string
+	Remove     bool
+}
+
+// Client manages the peers of wireguard devices
+type Client interface {
+	// Peers lists the peers configured in the device
+	Peers(iface string) ([]Peer, error)
+	// ConfigurePeers applies all the peer configs in a single operation,
+	// peers not present in the list are left untouched.
+	ConfigurePeers(iface string, peers []PeerConfig) error
+	// ConfigureInterface applies the private key and the listen port
+	// to a running device, the remaining settings are ignored.
+	ConfigureInterface(iface string, c *InterfaceConfig) error
+	Close() error
+}
+
+// NewClient creates a client using the given backend,
+// an empty backend means BackendAuto.
+func NewClient(backend Backend) (Client, error) {
+	switch backend {
+	case BackendExec:
+		return &execClient{}, nil
+	case BackendNetlink:
+		return newWGCtrlClient()
+	case BackendAuto, "":
+		c, err := newWGCtrlClient()
+		if err != nil {
+			log.Warnf("failed initializing netlink client, using the wg tool: %v", err)
+			return &execClient{}, nil
+		}
+		return &fallbackClient{primary: c, fallback: &execClient{}}, nil
+	}
+	return nil, fmt.Errorf("unknown wireguard backend %q", backend)
+}
+
+// fallbackClient uses the fallback client when the device
+// doesn't exist for the primary one
+type fallbackClient struct {
+	primary  Client
+	fallback Client
+}
+
+func (c *fallbackClient) Peers(iface string) ([]Peer, error) {
+	peers, err := c.primary.Peers(iface)
+	if os.IsNotExist(err) {
+		return c.fallback.Peers(iface)
+	}
+	return peers, err
+}
+
+func (c *fallbackClient) ConfigurePeers(iface string, peers []PeerConfig) error {
+	err := c.primary.ConfigurePeers(iface, peers)
+	if os.IsNotExist(err) {
+		return c.fallback.ConfigurePeers(iface, peers)
+	}
+	return err
+}
+
+func (c *fallbackClient) ConfigureInterface(iface string, cfg *InterfaceConfig) error {
+	err := c.primary.ConfigureInterface(iface, cfg)
+	if os.IsNotExist(err) {
+		return c.fallback.ConfigureInterface(iface, cfg)
+	}
+	return err
+}
+
+func (c *fallbackClient) Close() error {
+	return c.primary.Close()
+}
@@ -0,0 +1,117 @@
+package wgtools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InterfaceConfig is the [Interface] section of a wg-quick config file
+type InterfaceConfig struct {
+	PrivateKey string
+	ListenPort int
+	Address    []string
+	PostUp     []string
+	PostDown   []string
+	// Extra are the remaining wg-quick settings (MTU, DNS, PreUp, ...)
+	// indexed by their lower case names
+	Extra map[string][]string
+}
+
+// InterfaceChanges are the differences between two interface configs
+type InterfaceChanges struct {
+	PrivateKey bool `json:"privateKey"`
+	ListenPort bool `json:"listenPort"`
+	// Restart is set when the changes can't be applied to a running
+	// interface, e.g.: the address or the hooks changed
+	Restart bool `json:"restart"`
+}
+
+// IsLive returns true if there are changes which could be applied to a running interface
+func (c InterfaceChanges) IsLive() bool {
+	return c.PrivateKey || c.ListenPort
+}
+
+// ParseInterfaceConfig parses the [Interface] section of a wg-quick config file,
+// the remaining sections are ignored.
+// https://git.zx2c4.com/WireGuard/about/src/tools/man/wg-quick.8
+func ParseInterfaceConfig(data []byte) (*InterfaceConfig, error) {
+	c := &InterfaceConfig{Extra: map[string][]string{}}
+	isInterface := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			isInterface = strings.EqualFold(line, "[Interface]")
+			continue
+		}
+		if !isInterface {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid line %d: %q", lineno, line)
+		}
+		key, val := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		switch key {
+		case "privatekey":
+			c.PrivateKey = val
+		case "listenport":
+			port, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid listen port at line %d: %v", lineno, err)
+			}
+			c.ListenPort = port
+		case "address":
+			for _, addr := range strings.Split(val, ",") {
+				c.Address = append(c.Address, strings.TrimSpace(addr))
+			}
+		case "postup":
+			c.PostUp = append(c.PostUp, val)
+		case "postdown":
+			c.PostDown = append(c.PostDown, val)
+		default:
+			c.Extra[key] = append(c.Extra[key], val)
+		}
+	}
+	return c, scanner.Err()
+}
+
+// DiffInterfaceConfig compares the current config of an interface with the desired one
+func DiffInterfaceConfig(current, desired *InterfaceConfig) InterfaceChanges {
+	changes := InterfaceChanges{
+		PrivateKey: current.PrivateKey != desired.PrivateKey,
+		ListenPort: current.ListenPort != desired.ListenPort,
+	}
+	changes.Restart = !equalStrings(current.Address, desired.Address) ||
+		!equalStrings(current.PostUp, desired.PostUp) ||
+		!equalStrings(current.PostDown, desired.PostDown) ||
+		len(current.Extra) != len(desired.Extra)
+	for key, val := range current.Extra {
+		if !equalStrings(val, desired.Extra[key]) {
+			changes.Restart = true
+		}
+	}
+	return changes
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package wgtools
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testInterfaceConfig = `[Interface]
+Address    = 10.100.0.1/24
+ListenPort = 51820
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+`
+
+func TestParseInterfaceConfig(t *testing.T) {
+	c, err := ParseInterfaceConfig([]byte(testInterfaceConfig))
+	if err != nil {
+		t.Fatalf("failed parsing config: %v", err)
+	}
+	want := &InterfaceConfig{
+		PrivateKey: "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		ListenPort: 51820,
+		Address:    []string{"10.100.0.1/24"},
+		PostUp:     []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+		PostDown:   []string{"iptables -D FORWARD -i %i -j ACCEPT"},
+		Extra:      map[string][]string{},
+	}
+	if diff := cmp.Diff(want, c); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+	if _, err := ParseInterfaceConfig([]byte("[Interface]\nListenPort = foo\n")); err == nil {
+		t.Fatalf("expected an error parsing an invalid port, but none occurred")
+	}
+}
+
+func TestDiffInterfaceConfig(t *testing.T) {
+	current, err := ParseInterfaceConfig([]byte(testInterfaceConfig))
+	if err != nil {
+		t.Fatalf("failed parsing config: %v", err)
+	}
+	for _, tt := range []struct {
+		name    string
+		old     string
+		new     string
+		changes InterfaceChanges
+	}{
+		{
+			name: "no changes",
+		},
+		{
+			name:    "private key",
+			old:     "PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+			new:     "PrivateKey = 2Ht1wXrAtMB7RIrgQlT0jjdg5n6b2pcrk9a1xz2Zs3Y=",
+			changes: InterfaceChanges{PrivateKey: true},
+		},
+		{
+			name:    "listen port",
+			old:     "ListenPort = 51820",
+			new:     "ListenPort = 51821",
+			changes: InterfaceChanges{ListenPort: true},
+		},
+		{
+			name:    "address",
+			old:     "Address    = 10.100.0.1/24",
+			new:     "Address    = 10.100.0.1/24, fd00::1/64",
+			changes: InterfaceChanges{Restart: true},
+		},
+		{
+			name:    "hooks",
+			old:     "PostDown = iptables -D FORWARD -i %i -j ACCEPT\n",
+			changes: InterfaceChanges{Restart: true},
+		},
+		{
+			name:    "extra settings",
+			old:     "ListenPort = 51820",
+			new:     "ListenPort = 51821\nMTU = 1380",
+			changes: InterfaceChanges{ListenPort: true, Restart: true},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			desired, err := ParseInterfaceConfig([]byte(strings.Replace(testInterfaceConfig, tt.old, tt.new, 1)))
+			if err != nil {
+				t.Fatalf("failed parsing config: %v", err)
+			}
+			if diff := cmp.Diff(tt.changes, DiffInterfaceConfig(current, desired)); diff != "" {
+				t.Fatalf("unexpected changes (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package wgtools
+
+import (
+	"sort"
+	"sync"
+)
+
+// Device is a wireguard interface which peers could be listed and configured
+type Device interface {
+	Peers() ([]Peer, error)
+	ConfigurePeers(peers []PeerConfig) error
+}
+
+type device struct {
+	client Client
+	iface  string
+}
+
+// NewDevice binds a client to a wireguard interface
+func NewDevice(c Client, iface string) Device {
+	return &device{client: c, iface: iface}
+}
+
+func (d *device) Peers() ([]Peer, error) {
+	return d.client.Peers(d.iface)
+}
+
+func (d *device) ConfigurePeers(peers []PeerConfig) error {
+	return d.client.ConfigurePeers(d.iface, peers)
+}
+
+// FakeDevice is an in-memory Device for testing
+type FakeDevice struct {
+	mu    sync.Mutex
+	peers map[string]Peer
+
+	// ConfigureErr is returned by ConfigurePeers when it's set
+	ConfigureErr error
+	// Configured counts the calls to ConfigurePeers
+	Configured int
+}
+
+// NewFakeDevice creates an in-memory device with the given peers
+func NewFakeDevice(peers ...Peer) *FakeDevice {
+	d := &FakeDevice{peers: map[string]Peer{}}
+	for _, p := range peers {
+		d.peers[p.PublicKey] = p
+	}
+	return d
+}
+
+// Peers returns the peers sorted by their public keys
+func (d *FakeDevice) Peers() ([]Peer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var peers []Peer
+	for _, p := range d.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PublicKey < peers[j].PublicKey })
+	return peers, nil
+}
+
+// ConfigurePeers applies the peer configs, nothing is applied if ConfigureErr is set
+func (d *FakeDevice) ConfigurePeers(peers []PeerConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Configured++
+	if d.ConfigureErr != nil {
+		return d.ConfigureErr
+	}
+	for _, p := range peers {
+		if p.Remove {
+			delete(d.peers, p.PublicKey)
+			continue
+		}
+		d.peers[p.PublicKey] = Peer{PublicKey: p.PublicKey, AllowedIPs: p.AllowedIPs}
+	}
+	return nil
+}
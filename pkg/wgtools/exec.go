@@ -0,0 +1,94 @@
+package wgtools
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execClient manages the devices executing the wg tool
+type execClient struct{}
+
+// Peers parses the output of "wg show <iface> dump", the first line is the interface
+// and the remaining ones are the peers with the tab separated fields: public-key,
+// preshared-key, endpoint, allowed-ips, latest-handshake, transfer-rx, transfer-tx
+// and persistent-keepalive.
+func (e *execClient) Peers(iface string) ([]Peer, error) {
+	cmd := exec.Command("wg", "show", iface, "dump")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v. %v", strings.TrimSuffix(string(output), "\n"), err)
+	}
+	return parseDump(output)
+}
+
+func parseDump(output []byte) ([]Peer, error) {
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	var peers []Peer
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("unexpected peer line in dump: %q", line)
+		}
+		peer := Peer{PublicKey: fields[0]}
+		if fields[2] != "(none)" {
+			peer.Endpoint = fields[2]
+		}
+		if fields[3] != "(none)" {
+			peer.AllowedIPs = strings.Split(fields[3], ",")
+		}
+		handshake, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latest handshake of peer %q: %v", fields[0], err)
+		}
+		if handshake > 0 {
+			peer.LastHandshake = time.Unix(handshake, 0)
+		}
+		if peer.ReceiveBytes, err = strconv.ParseInt(fields[5], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid transfer rx of peer %q: %v", fields[0], err)
+		}
+		if peer.TransmitBytes, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid transfer tx of peer %q: %v", fields[0], err)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// ConfigurePeers executes a single "wg set" with all the peers
+func (e *execClient) ConfigurePeers(iface string, peers []PeerConfig) error {
+	if len(peers) == 0 {
+		return nil
+	}
+	args := []string{"set", iface}
+	for _, p := range peers {
+		args = append(args, "peer", p.PublicKey)
+		if p.Remove {
+			args = append(args, "remove")
+			continue
+		}
+		args = append(args, "allowed-ips", strings.Join(p.AllowedIPs, ","))
+	}
+	cmd := exec.Command("wg", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v. %v", strings.TrimSuffix(string(output), "\n"), err)
+	}
+	return nil
+}
+
+// ConfigureInterface executes "wg set", the private key is read from the stdin
+func (e *execClient) ConfigureInterface(iface string, c *InterfaceConfig) error {
+	cmd := exec.Command("wg", "set", iface,
+		"listen-port", strconv.Itoa(c.ListenPort),
+		"private-key", "/dev/stdin",
+	)
+	cmd.Stdin = strings.NewReader(c.PrivateKey)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v. %v", strings.TrimSuffix(string(output), "\n"), err)
+	}
+	return nil
+}
+
+func (e *execClient) Close() error { return nil }
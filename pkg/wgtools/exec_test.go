@@ -0,0 +1,32 @@
+package wgtools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDump(t *testing.T) {
+	dump := "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\tHIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=\t51820\toff\n" +
+		"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\t(none)\t192.0.2.10:51820\t10.100.0.2/32,10.200.0.0/24\t1581012000\t2048\t4096\toff\n" +
+		"TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=\t(none)\t(none)\t(none)\t0\t0\t0\toff\n"
+	peers, err := parseDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("failed parsing dump: %v", err)
+	}
+	want := []Peer{
+		{
+			PublicKey:     "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+			AllowedIPs:    []string{"10.100.0.2/32", "10.200.0.0/24"},
+			Endpoint:      "192.0.2.10:51820",
+			LastHandshake: time.Unix(1581012000, 0),
+			ReceiveBytes:  2048,
+			TransmitBytes: 4096,
+		},
+		{PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="},
+	}
+	if diff := cmp.Diff(want, peers); diff != "" {
+		t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+	}
+}
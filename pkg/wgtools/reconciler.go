@@ -0,0 +1,166 @@
+package wgtools
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerReconciler converges the peers of a device to the peers of the store
+type PeerReconciler struct {
+	device Device
+}
+
+// ReconcileResult summarizes a reconcile of a device
+type ReconcileResult struct {
+	// Peers are the peers found in the device before applying the changes
+	Peers []Peer
+	// Changes are the peer configs applied to the device
+	Changes []PeerConfig
+}
+
+// NewPeerReconciler creates a reconciler for the given device
+func NewPeerReconciler(device Device) *PeerReconciler {
+	return &PeerReconciler{device: device}
+}
+
+// Plan computes the changes required by the desired and archived peers without applying them
+func (r *PeerReconciler) Plan(logf *log.Entry, desiredPeers, archivedPeers []api.Peer) (*ReconcileResult, error) {
+	currentPeers, err := r.device.Peers()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing local peers: %v", err)
+	}
+	return &ReconcileResult{
+		Peers:   currentPeers,
+		Changes: diffPeers(logf, desiredPeers, archivedPeers, currentPeers),
+	}, nil
+}
+
+// Reconcile applies the changes required by the desired and archived peers in a single call.
+// The result is returned along with the error when the changes fail to be applied.
+func (r *PeerReconciler) Reconcile(logf *log.Entry, desiredPeers, archivedPeers []api.Peer) (*ReconcileResult, error) {
+	result, err := r.Plan(logf, desiredPeers, archivedPeers)
+	if err != nil || len(result.Changes) == 0 {
+		return result, err
+	}
+	if err := r.device.ConfigurePeers(result.Changes); err != nil {
+		return result, fmt.Errorf("failed configuring %d peer(s): %v", len(result.Changes), err)
+	}
+	return result, nil
+}
+
+// CollectStats maps the runtime state of the local peers to the desired peers by their
+// public keys. Only the peers which connected for the first time or roamed to another
+// endpoint are returned, any other change is returned once the stats are older than
+// refreshAfter: the counters change with any traffic and the handshakes are renewed
+// every two minutes, writing them on every sync would flood the store.
+func CollectStats(desiredPeers []api.Peer, localPeers []Peer, refreshAfter time.Duration) map[string]*api.PeerStats {
+	stats := map[string]*api.PeerStats{}
+	peersByPubKey := map[string]Peer{}
+	for _, p := range localPeers {
+		peersByPubKey[p.PublicKey] = p
+	}
+	now := time.Now().UTC()
+	for _, desired := range desiredPeers {
+		local, ok := peersByPubKey[desired.PublicKeyString()]
+		if !ok || desired.PublicKeyString() == "" {
+			continue
+		}
+		s := &api.PeerStats{
+			TransferRx: local.ReceiveBytes,
+			TransferTx: local.TransmitBytes,
+			Endpoint:   local.Endpoint,
+		}
+		if !local.LastHandshake.IsZero() {
+			s.LastHandshake = local.LastHandshake.UTC().Format(time.RFC3339)
+		}
+		if old := desired.Status.Stats; old != nil {
+			s.UpdatedAt = old.UpdatedAt
+			if *s == *old {
+				continue
+			}
+			updatedAt, _ := time.Parse(time.RFC3339, old.UpdatedAt)
+			connected := old.LastHandshake == "" && s.LastHandshake != ""
+			if !connected && s.Endpoint == old.Endpoint && now.Sub(updatedAt) < refreshAfter {
+				continue
+			}
+		} else if *s == (api.PeerStats{}) {
+			continue
+		}
+		s.UpdatedAt = now.Format(time.RFC3339)
+		stats[desired.UID] = s
+	}
+	return stats
+}
+
+// diffPeers computes the changes required to converge the local peers to the desired ones.
+// Blocked, expired, auto locked, deleted and unknown local peers are removed and the
+// active ones are added if they don't exist locally or their allowed ips changed.
+func diffPeers(logf *log.Entry, desiredPeers, archivedPeers []api.Peer, currentPeers []Peer) []PeerConfig {
+	deletedPeers := map[string]api.Peer{}
+	for _, p := range archivedPeers {
+		deletedPeers[p.PublicKeyString()] = p
+	}
+	activePeers := map[string]api.Peer{}
+	revokedPeers := map[string]api.Peer{}
+	for _, peer := range desiredPeers {
+		shouldAutoLock := peer.ShouldAutoLock()
+		logf.Debugf("op=revoke, peer=%v, status=%v, autolock=%v", peer.UID, peer.GetStatus(), shouldAutoLock)
+		switch {
+		case peer.GetStatus() == api.PeerBlocked || peer.GetStatus() == api.PeerActive && shouldAutoLock:
+			revokedPeers[peer.PublicKeyString()] = peer
+		// don't process pending, locked or expired peers
+		case peer.GetStatus() == api.PeerActive:
+			activePeers[peer.PublicKeyString()] = peer
+		}
+	}
+
+	var peerConfigs []PeerConfig
+	localPeers := map[string]Peer{}
+	for _, cur := range currentPeers {
+		localPeers[cur.PublicKey] = cur
+		logf.Debugf("op=conciliate, peer=%s", cur.PublicKey)
+		if _, ok := activePeers[cur.PublicKey]; ok {
+			continue
+		}
+		if p, ok := revokedPeers[cur.PublicKey]; ok {
+			logf.Infof("Removing dirty peer %v", p.UID)
+		} else if p, ok := deletedPeers[cur.PublicKey]; ok {
+			logf.Infof("Removing deleted peer %v, deleted by %v at %v", p.UID, p.DeletedBy, p.DeletedAt)
+		} else {
+			logf.Infof("Removing local peer %s", cur.PublicKey)
+		}
+		peerConfigs = append(peerConfigs, PeerConfig{PublicKey: cur.PublicKey, Remove: true})
+	}
+	for _, desired := range desiredPeers {
+		pubkey := desired.PublicKeyString()
+		if _, ok := activePeers[pubkey]; !ok {
+			continue
+		}
+		logf.Debugf("op=add, peer=%s, status=%v", desired.UID, desired.GetStatus())
+		allowedIPs := desired.GetAllowedIPs()
+		cur, exists := localPeers[pubkey]
+		if exists && equalAddresses(cur.AllowedIPs, allowedIPs) {
+			continue
+		}
+		logf.Debugf("Adding peer %v/%v", desired.UID, pubkey)
+		peerConfigs = append(peerConfigs, PeerConfig{
+			PublicKey:  pubkey,
+			AllowedIPs: allowedIPs,
+		})
+	}
+	return peerConfigs
+}
+
+// equalAddresses compares two lists of addresses ignoring their order,
+// the device could report the IPv4 and IPv6 addresses in any order.
+func equalAddresses(a, b []string) bool {
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return equalStrings(a, b)
+}
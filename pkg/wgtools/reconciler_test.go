@@ -0,0 +1,234 @@
+package wgtools
+
+import (
+	"errors"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sandromello/wgadmin/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestKey(b byte) *api.Key {
+	return &api.Key{b}
+}
+
+func newTestPeer(uid string, key *api.Key, mutate func(p *api.Peer)) api.Peer {
+	now := time.Now().UTC().Format(time.RFC3339)
+	p := api.Peer{
+		Metadata: api.Metadata{UID: uid, CreatedAt: now, UpdatedAt: now},
+		Spec:     api.PeerSpec{AllowedIPs: "10.0.0.2/32"},
+		Status:   api.PeerStatus{PublicKey: key},
+	}
+	if mutate != nil {
+		mutate(&p)
+	}
+	return p
+}
+
+func TestPeerReconcile(t *testing.T) {
+	logf := log.NewEntry(&log.Logger{Out: ioutil.Discard, Formatter: &log.TextFormatter{}})
+	expired := time.Now().UTC().Add(-2 * time.Hour).Format(time.RFC3339)
+	active := newTestPeer("dev/active", newTestKey(1), nil)
+	blocked := newTestPeer("dev/blocked", newTestKey(2), func(p *api.Peer) { p.Spec.Blocked = true })
+	expiredPeer := newTestPeer("dev/expired", newTestKey(3), func(p *api.Peer) {
+		p.Spec.ExpireAction = api.PeerExpireActionReset
+		p.Spec.ExpireDuration = "1h"
+		p.CreatedAt = expired
+	})
+	autoLocked := newTestPeer("dev/autolocked", nil, func(p *api.Peer) {
+		p.Spec.PersistentPublicKey = newTestKey(4)
+		p.Spec.ExpireAction = api.PeerExpireActionBlock
+		p.Spec.ExpireDuration = "1h"
+		p.UpdatedAt = expired
+	})
+	persistent := newTestPeer("dev/persistent", nil, func(p *api.Peer) {
+		p.Spec.PersistentPublicKey = newTestKey(5)
+		p.Spec.AllowedIPs = "10.0.0.5/32, 192.168.0.0/24"
+	})
+	dualStack := newTestPeer("dev/dualstack", newTestKey(6), func(p *api.Peer) {
+		p.Spec.AllowedIPs = "10.0.0.6/32, fd00::6/128"
+	})
+	site := newTestPeer("dev/site", newTestKey(7), func(p *api.Peer) {
+		p.Spec.AllowedIPs = "10.0.0.7/32"
+		p.Spec.Subnets = []string{"192.168.10.0/24"}
+	})
+	pending := newTestPeer("dev/pending", nil, nil)
+	localPeer := func(p api.Peer, allowedIPs ...string) Peer {
+		if len(allowedIPs) == 0 {
+			allowedIPs = []string{p.Spec.AllowedIPs}
+		}
+		return Peer{PublicKey: p.PublicKeyString(), AllowedIPs: allowedIPs}
+	}
+
+	for _, tt := range []struct {
+		name        string
+		desired     []api.Peer
+		archived    []api.Peer
+		local       []Peer
+		wantPeers   []Peer
+		wantChanges int
+	}{
+		{
+			name:        "add active peer",
+			desired:     []api.Peer{active, pending},
+			wantPeers:   []Peer{localPeer(active)},
+			wantChanges: 1,
+		},
+		{
+			name:      "keep active peer",
+			desired:   []api.Peer{active},
+			local:     []Peer{localPeer(active)},
+			wantPeers: []Peer{localPeer(active)},
+		},
+		{
+			name:        "update allowed ips",
+			desired:     []api.Peer{active},
+			local:       []Peer{localPeer(active, "10.0.0.9/32")},
+			wantPeers:   []Peer{localPeer(active)},
+			wantChanges: 1,
+		},
+		{
+			name:        "remove blocked peer",
+			desired:     []api.Peer{active, blocked},
+			local:       []Peer{localPeer(active), localPeer(blocked)},
+			wantPeers:   []Peer{localPeer(active)},
+			wantChanges: 1,
+		},
+		{
+			name:        "remove expired peer",
+			desired:     []api.Peer{expiredPeer},
+			local:       []Peer{localPeer(expiredPeer)},
+			wantChanges: 1,
+		},
+		{
+			name:        "remove auto locked peer",
+			desired:     []api.Peer{autoLocked},
+			local:       []Peer{localPeer(autoLocked)},
+			wantChanges: 1,
+		},
+		{
+			name:        "remove deleted and unknown local peers",
+			desired:     []api.Peer{active},
+			archived:    []api.Peer{blocked},
+			local:       []Peer{localPeer(active), localPeer(blocked), {PublicKey: newTestKey(9).String()}},
+			wantPeers:   []Peer{localPeer(active)},
+			wantChanges: 2,
+		},
+		{
+			name:        "add persistent key peer",
+			desired:     []api.Peer{persistent},
+			wantPeers:   []Peer{localPeer(persistent, "10.0.0.5/32", "192.168.0.0/24")},
+			wantChanges: 1,
+		},
+		{
+			name:      "keep persistent key peer",
+			desired:   []api.Peer{persistent},
+			local:     []Peer{localPeer(persistent, "10.0.0.5/32", "192.168.0.0/24")},
+			wantPeers: []Peer{localPeer(persistent, "10.0.0.5/32", "192.168.0.0/24")},
+		},
+		{
+			name:      "keep dual-stack peer in any order",
+			desired:   []api.Peer{dualStack},
+			local:     []Peer{localPeer(dualStack, "fd00::6/128", "10.0.0.6/32")},
+			wantPeers: []Peer{localPeer(dualStack, "fd00::6/128", "10.0.0.6/32")},
+		},
+		{
+			name:        "add the subnets of a site-to-site peer",
+			desired:     []api.Peer{site},
+			local:       []Peer{localPeer(site, "10.0.0.7/32")},
+			wantPeers:   []Peer{localPeer(site, "10.0.0.7/32", "192.168.10.0/24")},
+			wantChanges: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			device := NewFakeDevice(tt.local...)
+			result, err := NewPeerReconciler(device).Reconcile(logf, tt.desired, tt.archived)
+			if err != nil {
+				t.Fatalf("failed reconciling peers: %v", err)
+			}
+			if len(result.Changes) != tt.wantChanges {
+				t.Fatalf("expected %d change(s), got %#v", tt.wantChanges, result.Changes)
+			}
+			got, _ := device.Peers()
+			if diff := cmp.Diff(tt.wantPeers, got); diff != "" {
+				t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+			}
+			// a second pass must converge without changes
+			result, err = NewPeerReconciler(device).Reconcile(logf, tt.desired, tt.archived)
+			if err != nil {
+				t.Fatalf("failed reconciling peers: %v", err)
+			}
+			if len(result.Changes) != 0 {
+				t.Fatalf("expected no changes, got %#v", result.Changes)
+			}
+		})
+	}
+}
+
+func TestPeerReconcileConfigureError(t *testing.T) {
+	logf := log.NewEntry(&log.Logger{Out: ioutil.Discard, Formatter: &log.TextFormatter{}})
+	device := NewFakeDevice()
+	device.ConfigureErr = errors.New("device busy")
+	peer := newTestPeer("dev/active", newTestKey(1), nil)
+	if _, err := NewPeerReconciler(device).Reconcile(logf, []api.Peer{peer}, nil); err == nil {
+		t.Fatalf("expected an error configuring peers, but none occurred")
+	}
+	if device.Configured != 1 {
+		t.Fatalf("expected a single configure call, got %d", device.Configured)
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	handshake := time.Date(2020, 2, 6, 18, 0, 0, 0, time.UTC)
+	recently := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	connected := newTestPeer("dev/connected", newTestKey(1), nil)
+	unchanged := newTestPeer("dev/unchanged", newTestKey(2), func(p *api.Peer) {
+		p.Status.Stats = &api.PeerStats{TransferRx: 10, TransferTx: 20, UpdatedAt: "2020-02-06T18:00:00Z"}
+	})
+	idle := newTestPeer("dev/idle", newTestKey(3), nil)
+	missing := newTestPeer("dev/missing", newTestKey(4), nil)
+	oldStats := &api.PeerStats{LastHandshake: "2020-02-06T18:00:00Z", TransferRx: 10, TransferTx: 20, Endpoint: "192.0.2.11:51820", UpdatedAt: recently}
+	counters := newTestPeer("dev/counters", newTestKey(5), func(p *api.Peer) { p.Status.Stats = oldStats })
+	roamed := newTestPeer("dev/roamed", newTestKey(6), func(p *api.Peer) { p.Status.Stats = oldStats })
+	stale := newTestPeer("dev/stale", newTestKey(7), func(p *api.Peer) {
+		s := *oldStats
+		s.UpdatedAt = "2020-02-06T18:00:00Z"
+		p.Status.Stats = &s
+	})
+	local := []Peer{
+		{PublicKey: connected.PublicKeyString(), Endpoint: "192.0.2.10:51820", LastHandshake: handshake, ReceiveBytes: 1, TransmitBytes: 2},
+		{PublicKey: unchanged.PublicKeyString(), ReceiveBytes: 10, TransmitBytes: 20},
+		{PublicKey: idle.PublicKeyString()},
+		// only the counters and the handshake changed since the last write
+		{PublicKey: counters.PublicKeyString(), Endpoint: "192.0.2.11:51820", LastHandshake: handshake.Add(2 * time.Minute), ReceiveBytes: 30, TransmitBytes: 40},
+		{PublicKey: roamed.PublicKeyString(), Endpoint: "198.51.100.1:51820", LastHandshake: handshake, ReceiveBytes: 10, TransmitBytes: 20},
+		{PublicKey: stale.PublicKeyString(), Endpoint: "192.0.2.11:51820", LastHandshake: handshake, ReceiveBytes: 30, TransmitBytes: 40},
+	}
+	stats := CollectStats([]api.Peer{connected, unchanged, idle, missing, counters, roamed, stale}, local, 10*time.Minute)
+	var uids []string
+	for uid := range stats {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	if diff := cmp.Diff([]string{"dev/connected", "dev/roamed", "dev/stale"}, uids); diff != "" {
+		t.Fatalf("unexpected peers with stats (-want +got):\n%s", diff)
+	}
+	got := *stats[connected.UID]
+	if got.UpdatedAt == "" {
+		t.Fatalf("expected the stats to have an update time")
+	}
+	got.UpdatedAt = ""
+	want := api.PeerStats{
+		LastHandshake: "2020-02-06T18:00:00Z",
+		TransferRx:    1,
+		TransferTx:    2,
+		Endpoint:      "192.0.2.10:51820",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected stats (-want +got):\n%s", diff)
+	}
+}
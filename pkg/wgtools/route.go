@@ -0,0 +1,196 @@
+package wgtools
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sandromello/wgadmin/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// routeProtocol tags the routes installed by wgadmin, the routes
+// added by other tools (e.g.: wg-quick or the PostUp commands) are never removed.
+const routeProtocol = "250"
+
+// RouteConfig adds or removes the route of a subnet through a wireguard device
+type RouteConfig struct {
+	Subnet string
+	Remove bool
+}
+
+// Router manages the routes of a wireguard interface
+type Router interface {
+	// Routes lists the subnets routed through the interface by wgadmin
+	Routes() ([]string, error)
+	ConfigureRoutes(routes []RouteConfig) error
+}
+
+type ipRouter struct {
+	iface string
+}
+
+// NewRouter creates a router for the interface executing the ip tool
+func NewRouter(iface string) Router {
+	return &ipRouter{iface: iface}
+}
+
+func (r *ipRouter) Routes() ([]string, error) {
+	var routes []string
+	for _, family := range []string{"-4", "-6"} {
+		cmd := exec.Command("ip", family, "route", "show", "dev", r.iface, "proto", routeProtocol)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%v. %v", strings.TrimSuffix(string(output), "\n"), err)
+		}
+		routes = append(routes, parseRoutes(output)...)
+	}
+	return routes, nil
+}
+
+// parseRoutes parses the output of "ip route show dev <iface>", the first field of
+// each line is the destination of the route and the host routes don't have a prefix.
+func parseRoutes(output []byte) []string {
+	var routes []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		subnet := fields[0]
+		if !strings.Contains(subnet, "/") {
+			if ip := net.ParseIP(subnet); ip != nil && ip.To4() == nil {
+				subnet += "/128"
+			} else {
+				subnet += "/32"
+			}
+		}
+		routes = append(routes, subnet)
+	}
+	return routes
+}
+
+// ConfigureRoutes executes "ip route replace" or "ip route del" for each route
+func (r *ipRouter) ConfigureRoutes(routes []RouteConfig) error {
+	for _, route := range routes {
+		action := "replace"
+		if route.Remove {
+			action = "del"
+		}
+		cmd := exec.Command("ip", "route", action, route.Subnet, "dev", r.iface, "proto", routeProtocol)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v. %v", strings.TrimSuffix(string(output), "\n"), err)
+		}
+	}
+	return nil
+}
+
+// FakeRouter is an in-memory Router for testing
+type FakeRouter struct {
+	mu     sync.Mutex
+	routes map[string]bool
+}
+
+// NewFakeRouter creates an in-memory router with the given routes
+func NewFakeRouter(routes ...string) *FakeRouter {
+	r := &FakeRouter{routes: map[string]bool{}}
+	for _, subnet := range routes {
+		r.routes[subnet] = true
+	}
+	return r
+}
+
+// Routes returns the sorted routes
+func (r *FakeRouter) Routes() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var routes []string
+	for subnet := range r.routes {
+		routes = append(routes, subnet)
+	}
+	sort.Strings(routes)
+	return routes, nil
+}
+
+// ConfigureRoutes applies the route configs
+func (r *FakeRouter) ConfigureRoutes(routes []RouteConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, route := range routes {
+		if route.Remove {
+			delete(r.routes, route.Subnet)
+			continue
+		}
+		r.routes[route.Subnet] = true
+	}
+	return nil
+}
+
+// RouteReconciler converges the routes of an interface to the subnets of the active peers
+type RouteReconciler struct {
+	router Router
+}
+
+// NewRouteReconciler creates a reconciler for the given router
+func NewRouteReconciler(router Router) *RouteReconciler {
+	return &RouteReconciler{router: router}
+}
+
+// Reconcile routes the subnets of the active peers through the interface and removes
+// the routes of the remaining ones, the applied changes are returned.
+func (r *RouteReconciler) Reconcile(logf *log.Entry, desiredPeers []api.Peer) ([]RouteConfig, error) {
+	currentRoutes, err := r.router.Routes()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing local routes: %v", err)
+	}
+	changes := diffRoutes(logf, desiredPeers, currentRoutes)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := r.router.ConfigureRoutes(changes); err != nil {
+		return changes, fmt.Errorf("failed configuring %d route(s): %v", len(changes), err)
+	}
+	return changes, nil
+}
+
+// diffRoutes computes the changes required to route only the subnets of the active peers,
+// blocked, expired, auto locked and pending peers don't have routes.
+func diffRoutes(logf *log.Entry, desiredPeers []api.Peer, currentRoutes []string) []RouteConfig {
+	desired := map[string]bool{}
+	for _, peer := range desiredPeers {
+		if peer.GetStatus() != api.PeerActive || peer.ShouldAutoLock() {
+			continue
+		}
+		for _, subnet := range peer.Spec.Subnets {
+			// the routes are listed in their canonical form
+			if ipnet := api.ParseCIDR(subnet); ipnet != nil {
+				desired[ipnet.String()] = true
+			}
+		}
+	}
+	var changes []RouteConfig
+	current := map[string]bool{}
+	for _, subnet := range currentRoutes {
+		current[subnet] = true
+		if desired[subnet] {
+			continue
+		}
+		logf.Infof("Removing route %v", subnet)
+		changes = append(changes, RouteConfig{Subnet: subnet, Remove: true})
+	}
+	var subnets []string
+	for subnet := range desired {
+		if !current[subnet] {
+			subnets = append(subnets, subnet)
+		}
+	}
+	sort.Strings(subnets)
+	for _, subnet := range subnets {
+		logf.Infof("Adding route %v", subnet)
+		changes = append(changes, RouteConfig{Subnet: subnet})
+	}
+	return changes
+}
@@ -0,0 +1,76 @@
+package wgtools
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sandromello/wgadmin/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseRoutes(t *testing.T) {
+	output := "192.168.10.0/24 scope link \n" +
+		"192.168.20.5 scope link \n" +
+		"fd00:10::/64 metric 1024 pref medium\n"
+	want := []string{"192.168.10.0/24", "192.168.20.5/32", "fd00:10::/64"}
+	if diff := cmp.Diff(want, parseRoutes([]byte(output))); diff != "" {
+		t.Fatalf("unexpected routes (-want +got):\n%s", diff)
+	}
+}
+
+func TestRouteReconcile(t *testing.T) {
+	logf := log.NewEntry(&log.Logger{Out: ioutil.Discard, Formatter: &log.TextFormatter{}})
+	site := newTestPeer("dev/site", newTestKey(1), func(p *api.Peer) {
+		p.Spec.Subnets = []string{"192.168.10.0/24", "fd00:10::/64"}
+	})
+	blocked := newTestPeer("dev/blocked", newTestKey(2), func(p *api.Peer) {
+		p.Spec.Blocked = true
+		p.Spec.Subnets = []string{"192.168.20.0/24"}
+	})
+	pending := newTestPeer("dev/pending", nil, func(p *api.Peer) {
+		p.Spec.Subnets = []string{"192.168.30.0/24"}
+	})
+
+	for _, tt := range []struct {
+		name        string
+		desired     []api.Peer
+		local       []string
+		wantRoutes  []string
+		wantChanges int
+	}{
+		{
+			name:        "add the subnets of active peers",
+			desired:     []api.Peer{site, blocked, pending},
+			wantRoutes:  []string{"192.168.10.0/24", "fd00:10::/64"},
+			wantChanges: 2,
+		},
+		{
+			name:       "keep the routes of active peers",
+			desired:    []api.Peer{site},
+			local:      []string{"192.168.10.0/24", "fd00:10::/64"},
+			wantRoutes: []string{"192.168.10.0/24", "fd00:10::/64"},
+		},
+		{
+			name:        "remove the routes of blocked and deleted peers",
+			desired:     []api.Peer{blocked},
+			local:       []string{"192.168.20.0/24", "192.168.40.0/24"},
+			wantChanges: 2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewFakeRouter(tt.local...)
+			changes, err := NewRouteReconciler(router).Reconcile(logf, tt.desired)
+			if err != nil {
+				t.Fatalf("failed reconciling routes: %v", err)
+			}
+			if len(changes) != tt.wantChanges {
+				t.Fatalf("expected %d change(s), got %#v", tt.wantChanges, changes)
+			}
+			got, _ := router.Routes()
+			if diff := cmp.Diff(tt.wantRoutes, got); diff != "" {
+				t.Fatalf("unexpected routes (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package wgtools
+
+import (
+	"fmt"
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// wgctrlClient manages the devices using netlink or
+// the userspace api (wireguard-go)
+type wgctrlClient struct {
+	c *wgctrl.Client
+}
+
+func newWGCtrlClient() (*wgctrlClient, error) {
+	c, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	return &wgctrlClient{c: c}, nil
+}
+
+func (w *wgctrlClient) Peers(iface string) ([]Peer, error) {
+	dev, err := w.c.Device(iface)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(dev.Peers))
+	for _, p := range dev.Peers {
+		peer := Peer{
+			PublicKey:     p.PublicKey.String(),
+			LastHandshake: p.LastHandshakeTime,
+			ReceiveBytes:  p.ReceiveBytes,
+			TransmitBytes: p.TransmitBytes,
+		}
+		if p.Endpoint != nil {
+			peer.Endpoint = p.Endpoint.String()
+		}
+		for _, ipnet := range p.AllowedIPs {
+			peer.AllowedIPs = append(peer.AllowedIPs, ipnet.String())
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+func (w *wgctrlClient) ConfigurePeers(iface string, peers []PeerConfig) error {
+	if len(peers) == 0 {
+		return nil
+	}
+	cfg := wgtypes.Config{}
+	for _, p := range peers {
+		pubKey, err := wgtypes.ParseKey(p.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key %q: %v", p.PublicKey, err)
+		}
+		peerConfig := wgtypes.PeerConfig{PublicKey: pubKey, Remove: p.Remove}
+		if !p.Remove {
+			peerConfig.ReplaceAllowedIPs = true
+			for _, allowedIP := range p.AllowedIPs {
+				_, ipnet, err := net.ParseCIDR(allowedIP)
+				if err != nil {
+					return fmt.Errorf("invalid allowed ip %q for peer %q: %v", allowedIP, p.PublicKey, err)
+				}
+				peerConfig.AllowedIPs = append(peerConfig.AllowedIPs, *ipnet)
+			}
+		}
+		cfg.Peers = append(cfg.Peers, peerConfig)
+	}
+	return w.c.ConfigureDevice(iface, cfg)
+}
+
+func (w *wgctrlClient) ConfigureInterface(iface string, c *InterfaceConfig) error {
+	privKey, err := wgtypes.ParseKey(c.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %v", err)
+	}
+	return w.c.ConfigureDevice(iface, wgtypes.Config{
+		PrivateKey: &privKey,
+		ListenPort: &c.ListenPort,
+	})
+}
+
+func (w *wgctrlClient) Close() error {
+	return w.c.Close()
+}
@@ -5,7 +5,6 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
-	"strings"
 )
 
 // FileExists will return an error if the file exists
@@ -42,28 +41,3 @@ func WGQuickDown(configPath string) ([]byte, error) {
 	cmd := exec.Command("wg-quick", "down", configPath)
 	return cmd.CombinedOutput()
 }
-
-// WGShowPeers list all local peers
-func WGShowPeers(iface string) ([]string, error) {
-	cmd := exec.Command("wg", "show", iface, "peers")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("%v. %v", string(output), err)
-	}
-	if len(output) == 0 {
-		return nil, nil
-	}
-	return strings.Split(strings.TrimSuffix(string(output), "\n"), "\n"), nil
-}
-
-// WGAddPeer add a local peer into Wireguard
-func WGAddPeer(iface, pubKey, allowedIPs string) ([]byte, error) {
-	cmd := exec.Command("wg", "set", iface, "peer", pubKey, "allowed-ips", allowedIPs)
-	return cmd.CombinedOutput()
-}
-
-// WGRemovePeer remove a local peer from Wireguard
-func WGRemovePeer(iface, pubKey string) ([]byte, error) {
-	cmd := exec.Command("wg", "set", iface, "peer", pubKey, "remove")
-	return cmd.CombinedOutput()
-}